@@ -0,0 +1,117 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/cznic/99c/core"
+	"github.com/cznic/99c/exe"
+	"github.com/cznic/virtual"
+)
+
+func caller(s string, va ...interface{}) {
+	if s == "" {
+		s = strings.Repeat("%v ", len(va))
+	}
+	_, fn, fl, _ := runtime.Caller(2)
+	fmt.Fprintf(os.Stderr, "# caller: %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintf(os.Stderr, s, va...)
+	fmt.Fprintln(os.Stderr)
+	_, fn, fl, _ = runtime.Caller(1)
+	fmt.Fprintf(os.Stderr, "# \tcallee: %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintln(os.Stderr)
+	os.Stderr.Sync()
+}
+
+func dbg(s string, va ...interface{}) {
+	if s == "" {
+		s = strings.Repeat("%v ", len(va))
+	}
+	_, fn, fl, _ := runtime.Caller(1)
+	fmt.Fprintf(os.Stderr, "# dbg %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintf(os.Stderr, s, va...)
+	fmt.Fprintln(os.Stderr)
+	os.Stderr.Sync()
+}
+
+func TODO(...interface{}) string { //TODOOK
+	_, fn, fl, _ := runtime.Caller(1)
+	return fmt.Sprintf("# TODO: %s:%d:\n", path.Base(fn), fl) //TODOOK
+}
+
+func use(...interface{}) {}
+
+func init() {
+	use(caller, dbg, TODO) //TODOOK
+}
+
+// ============================================================================
+
+func TestLoadCore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "99dbg-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	b := &virtual.Binary{}
+	var buf bytes.Buffer
+	if err := exe.Write(&buf, &exe.Header{BuildID: exe.BuildID(b)}, b); err != nil {
+		t.Fatal(err)
+	}
+
+	executable := filepath.Join(dir, "a.out")
+	if err := ioutil.WriteFile(executable, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, v := range []struct {
+		buildID, executable string
+		ok                  bool
+	}{
+		{exe.BuildID(b), executable, true},
+		{"42", executable, false},
+		{exe.BuildID(b), "", false},
+	} {
+		buf.Reset()
+		c := &core.Core{BuildID: v.buildID, Executable: v.executable, Threads: []core.Thread{{PC: 0x12}}}
+		if _, err := c.WriteTo(&buf); err != nil {
+			t.Fatal(i, err)
+		}
+
+		fn := filepath.Join(dir, "a.core")
+		if err := ioutil.WriteFile(fn, buf.Bytes(), 0644); err != nil {
+			t.Fatal(i, err)
+		}
+
+		_, _, loaded, err := loadCore(fn, "")
+		if g, e := err == nil, v.ok; g != e {
+			t.Fatal(i, err)
+		}
+
+		if err != nil {
+			continue
+		}
+
+		if g, e := loaded.Threads[0].PC, uint64(0x12); g != e {
+			t.Fatalf("%v: got %#x, expected %#x", i, g, e)
+		}
+
+		// The executable given explicitly overrides the recorded one.
+		if _, _, _, err := loadCore(fn, filepath.Join(dir, "nosuch")); err == nil {
+			t.Fatal(i, "unexpected success")
+		}
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command 99dbg is a source level debugger of programs produced by the 99c
+// compiler.
+//
+// Usage
+//
+//	99dbg [-x script] -core file [a.out]
+//	99dbg -dap
+//
+// Flags
+//
+//	-core file
+//		inspect the core file file, the executable defaults to the one recorded
+//		in the core file
+//	-dap
+//		serve the Debug Adapter Protocol on stdin and stdout, for
+//		debugging in editors
+//	-x script
+//		execute the commands in the script file instead of reading them
+//		from stdin
+//
+// Debugging
+//
+// The virtual machine provides no way to execute a program under the control of
+// a debugger, so 99dbg inspects the memory and the call stack of crashed
+// programs saved in core files, see package github.com/cznic/99c/core. The
+// commands running the program fail. Compile the program with -g, otherwise
+// only PCs are shown.
+//
+//	$ 99dbg -core a.core
+//	(99dbg) backtrace
+//	#0  0x0001f in main (hello.c:4:2)
+//	#1  0x0000e in _start (crt0.c:15:1)
+//	(99dbg) quit
+//
+// Enter help to list the commands. See package
+// [github.com/cznic/99c/debugger](http://godoc.org/github.com/cznic/99c/debugger)
+// for their description.
+//
+// Variables
+//
+// print shows the global variables of the program as 64 bit words. frame
+// selects a frame of the call stack. The executables produced by 99c do not
+// describe the variables and types of the program, see package debuginfo, so
+// parameters and local variables cannot be printed and ptype and info locals
+// fail.
+//
+//	(99dbg) backtrace
+//	#0  0x00031 in dist (point.c:5:1)
+//	#1  0x00052 in main (point.c:12:2)
+//	(99dbg) print counter
+//	counter at 0x00001000 = 0x000000000000002a
+//
+// Scripts
+//
+// With -x the commands are read from a file, one per line, and the output is
+// written to stdout, which makes 99dbg usable in tests. Lines starting with #
+// are ignored. Errors of the commands are reported but do not stop the script.
+//
+//	$ cat inspect.gdb
+//	backtrace
+//	print counter
+//	$ 99dbg -x inspect.gdb -core a.core
+//
+// Editor debugging
+//
+// With -dap, 99dbg is a debug adapter: editors supporting the Debug Adapter
+// Protocol, eg. VS Code, start it and talk to it over its stdin and stdout. An
+// attach request inspects the core file given by its core attribute, the editor
+// shows the call stack of the crashed program and expands its variables. Launch
+// requests fail, 99dbg cannot run programs. See package
+// [github.com/cznic/99c/dap](http://godoc.org/github.com/cznic/99c/dap) for the
+// details.
+//
+// A VS Code configuration, for a debugger extension registering 99dbg -dap as
+// the adapter of the type 99dbg, looks like
+//
+//	{
+//		"type": "99dbg",
+//		"request": "attach",
+//		"name": "Inspect a.core",
+//		"core": "${workspaceFolder}/a.core"
+//	}
+//
+// Installation
+//
+// To install or update 99dbg
+//
+//      $ go get [-u] github.com/cznic/99c/99dbg
+//
+// Online documentation: [godoc.org/github.com/cznic/99c/99dbg](http://godoc.org/github.com/cznic/99c/99dbg)
+//
+// Changelog
+//
+// 2026-10-19: Parameters and local variables cannot be printed, 99c no longer
+// describes them in -g executables.
+//
+// 2026-10-19: 99dbg inspects core files only. The virtual machine provides no
+// way to execute a program under the control of a debugger.
+//
+// 2026-10-18: Add -dap, a Debug Adapter Protocol server for debugging in
+// editors.
+//
+// 2026-10-18: Print variables according to their C types. Add the frame,
+// ptype and info locals commands.
+//
+// 2026-10-18: Initial public release.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cznic/99c/core"
+	"github.com/cznic/99c/dap"
+	"github.com/cznic/99c/debugger"
+	"github.com/cznic/99c/exe"
+	"github.com/cznic/virtual"
+)
+
+func exit(code int, msg string, arg ...interface{}) {
+	if msg != "" {
+		fmt.Fprintf(os.Stderr, os.Args[0]+": "+msg, arg...)
+	}
+	os.Exit(code)
+}
+
+func main() {
+	coreFile := flag.String("core", "", "inspect the core file file, the executable defaults to the one recorded in the core file")
+	dapMode := flag.Bool("dap", false, "serve the Debug Adapter Protocol on stdin and stdout, for debugging in editors")
+	script := flag.String("x", "", "execute the commands in the script file instead of reading them from stdin")
+	flag.Parse()
+
+	if *dapMode {
+		if flag.NArg() != 0 || *coreFile != "" || *script != "" {
+			exit(2, "invalid arguments %v\n", os.Args)
+		}
+
+		if err := (&dap.Server{Attach: attach}).Serve(os.Stdin, os.Stdout); err != nil {
+			exit(1, "%v\n", err)
+		}
+
+		return
+	}
+
+	if *coreFile == "" || flag.NArg() > 1 {
+		exit(2, "invalid arguments %v\n", os.Args)
+	}
+
+	h, b, c, err := loadCore(*coreFile, flag.Arg(0))
+	if err != nil {
+		exit(1, "%v\n", err)
+	}
+
+	in := io.Reader(os.Stdin)
+	prompt := "(99dbg) "
+	if *script != "" {
+		f, err := os.Open(*script)
+		if err != nil {
+			exit(1, "%v\n", err)
+		}
+
+		defer f.Close()
+
+		in, prompt = f, ""
+	}
+	if err := debugger.New(debugger.CoreMachine(c), b, h.Debug, os.Stdout).Run(in, prompt); err != nil {
+		exit(1, "%v\n", err)
+	}
+}
+
+func readExecutable(fn string) (*exe.Header, *virtual.Binary, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer f.Close()
+
+	h, b, err := exe.Read(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", fn, err)
+	}
+
+	return h, b, nil
+}
+
+// loadCore reads the core file fn and the executable of the crashed program,
+// which is executable or, if empty, the one recorded in the core file.
+func loadCore(fn, executable string) (*exe.Header, *virtual.Binary, *core.Core, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	defer f.Close()
+
+	c, err := core.Read(f)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%s: %v", fn, err)
+	}
+
+	if executable == "" {
+		if executable = c.Executable; executable == "" {
+			return nil, nil, nil, fmt.Errorf("%s: executable not recorded, pass it as an argument", fn)
+		}
+	}
+
+	h, b, err := readExecutable(executable)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if id := exe.BuildID(b); id != c.BuildID {
+		return nil, nil, nil, fmt.Errorf("%s has build ID %s, %s was written by %s", executable, id, fn, c.BuildID)
+	}
+
+	return h, b, c, nil
+}
+
+// attach loads a core file for a DAP client.
+func attach(fn, executable string) (*dap.Target, error) {
+	h, b, c, err := loadCore(fn, executable)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dap.Target{Binary: b, Info: h.Debug, Machine: debugger.CoreMachine(c), Message: c.Message}, nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/cznic/99c/core"
+	"github.com/cznic/99c/debugger"
+	"github.com/cznic/99c/exe"
+)
+
+func tryCore(w io.Writer, fn string) bool {
+	f, err := os.Open(fn)
+	if err != nil {
+		exit(1, "%v\n", err)
+	}
+
+	defer f.Close()
+
+	c, err := core.Read(f)
+	if err != nil {
+		return false
+	}
+
+	tw := new(tabwriter.Writer)
+	tw.Init(w, 0, 8, 1, '\t', 0)
+
+	defer tw.Flush()
+
+	w = tw
+	fmt.Fprintf(w, "%T %s: %s\n", *c, fn, c.Message)
+	fmt.Fprintf(w, "executable %s\ntarget %s\nbuild ID %s\n", c.Executable, c.Target, c.BuildID)
+	pos := symbolizer(w, c)
+	for _, t := range c.Threads {
+		fmt.Fprintf(w, "Thread %v: pc %#05x, sp %#x, bp %#x, ap %#x\n", t.ID, t.PC, t.SP, t.BP, t.AP)
+		for _, pc := range append([]uint64{t.PC}, t.Stack...) {
+			fmt.Fprintf(w, "%#05x\t%s\n", pc, pos(pc))
+		}
+	}
+	if len(c.Files) != 0 {
+		fmt.Fprintln(w, "Open files")
+		for _, v := range c.Files {
+			fmt.Fprintf(w, "%v\t%s\toffset %v\tflags %#x\n", v.FD, v.Name, v.Offset, v.Flags)
+		}
+	}
+	for _, v := range c.Segments {
+		fmt.Fprintf(w, "%s segment %#x, %#x bytes\n%s\n", v.Name, v.Addr, len(v.Data), hex.Dump(v.Data))
+	}
+	return true
+}
+
+// symbolizer returns a function mapping PCs of the program which produced c to
+// source positions. It requires the executable of the program.
+func symbolizer(w io.Writer, c *core.Core) func(uint64) string {
+	none := func(uint64) string { return "" }
+	f, err := os.Open(c.Executable)
+	if err != nil {
+		fmt.Fprintf(w, "no symbols: %v\n", err)
+		return none
+	}
+
+	defer f.Close()
+
+	_, b, err := exe.Read(f)
+	if err != nil {
+		fmt.Fprintf(w, "no symbols: %v\n", err)
+		return none
+	}
+
+	if id := exe.BuildID(b); id != c.BuildID {
+		fmt.Fprintf(w, "no symbols: %s has build ID %s\n", c.Executable, id)
+		return none
+	}
+
+	return func(pc uint64) string {
+		switch fn, pos := debugger.Symbolize(b, pc); {
+		case fn == "":
+			return ""
+		case pos == "":
+			return fn
+		default:
+			return fmt.Sprintf("%s\t; %s", fn, pos)
+		}
+	}
+}
@@ -6,10 +6,14 @@
 //
 // Usage
 //
-// To dump object or binary files produced by the 99c compiler
+// To dump object or binary files produced by the 99c compiler or core files,
+// see package github.com/cznic/99c/core
 //
 //     99dump [files...]
 //
+// The positions of the PCs in a core file are shown if its executable is
+// still available and has the recorded build ID.
+//
 // Installation
 //
 // To install or update 99dump
@@ -20,6 +24,10 @@
 //
 // Changelog
 //
+// 2026-10-18: Dump core files.
+//
+// 2026-10-18: Show the target and the build ID of executables.
+//
 // 2017-10-09: Initial public release.
 //
 // Sample
@@ -124,6 +132,7 @@ import (
 	"sort"
 	"text/tabwriter"
 
+	"github.com/cznic/99c/exe"
 	"github.com/cznic/ir"
 	"github.com/cznic/virtual"
 	"github.com/cznic/xc"
@@ -153,7 +162,7 @@ func main() {
 		case filepath.Ext(arg) == ".o":
 			use(try(w, arg, obj) || try(w, arg, bin) || unknown(arg))
 		default:
-			use(try(w, arg, bin) || try(w, arg, obj) || unknown(arg))
+			use(tryCore(w, arg) || try(w, arg, bin) || try(w, arg, obj) || unknown(arg))
 		}
 	}
 }
@@ -178,14 +187,20 @@ func try(w io.Writer, fn string, bin bool) bool {
 	r := bufio.NewReader(f)
 	switch {
 	case bin:
-		var b virtual.Binary
-		if _, err := b.ReadFrom(r); err != nil {
+		h, b, err := exe.Read(r)
+		if err != nil {
 			return false
 		}
 
 		fmt.Fprintf(w, "%T %s: code %#05x, text %#05x, data %#05x, bss %#05x, pc2func %v, pc2line %v\n",
-			b, fn, len(b.Code), len(b.Text), len(b.Data), b.BSS, len(b.Functions), len(b.Lines),
+			*b, fn, len(b.Code), len(b.Text), len(b.Data), b.BSS, len(b.Functions), len(b.Lines),
 		)
+		if h.Target != "" {
+			fmt.Fprintf(w, "target %s\n", h.Target)
+		}
+		if h.BuildID != "" {
+			fmt.Fprintf(w, "build ID %s\n", h.BuildID)
+		}
 		virtual.DumpCode(w, b.Code, 0, b.Functions, b.Lines)
 		if len(b.Text) != 0 {
 			fmt.Fprintf(w, "Text segment\n%s\n", hex.Dump(b.Text))
@@ -158,8 +158,8 @@ import (
 	"sort"
 	"text/tabwriter"
 
+	"github.com/cznic/99c/exe"
 	"github.com/cznic/ir"
-	"github.com/cznic/virtual"
 	"github.com/cznic/xc"
 )
 
@@ -216,8 +216,8 @@ func try(w io.Writer, fn string, bin bool) bool {
 	var a []string
 	switch {
 	case bin:
-		var b virtual.Binary
-		if _, err := b.ReadFrom(r); err != nil {
+		_, b, err := exe.Read(r)
+		if err != nil {
 			return false
 		}
 
@@ -0,0 +1,120 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/cznic/99c/exe"
+	"github.com/cznic/virtual"
+)
+
+func caller(s string, va ...interface{}) {
+	if s == "" {
+		s = strings.Repeat("%v ", len(va))
+	}
+	_, fn, fl, _ := runtime.Caller(2)
+	fmt.Fprintf(os.Stderr, "# caller: %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintf(os.Stderr, s, va...)
+	fmt.Fprintln(os.Stderr)
+	_, fn, fl, _ = runtime.Caller(1)
+	fmt.Fprintf(os.Stderr, "# \tcallee: %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintln(os.Stderr)
+	os.Stderr.Sync()
+}
+
+func dbg(s string, va ...interface{}) {
+	if s == "" {
+		s = strings.Repeat("%v ", len(va))
+	}
+	_, fn, fl, _ := runtime.Caller(1)
+	fmt.Fprintf(os.Stderr, "# dbg %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintf(os.Stderr, s, va...)
+	fmt.Fprintln(os.Stderr)
+	os.Stderr.Sync()
+}
+
+func TODO(...interface{}) string { //TODOOK
+	_, fn, fl, _ := runtime.Caller(1)
+	return fmt.Sprintf("# TODO: %s:%d:\n", path.Base(fn), fl) //TODOOK
+}
+
+func use(...interface{}) {}
+
+func init() {
+	use(caller, dbg, TODO) //TODOOK
+}
+
+// ============================================================================
+
+func TestPack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "99pack-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	runner := filepath.Join(dir, "runner")
+	if err := ioutil.WriteFile(runner, []byte("runner"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, n, err := payload(runner); b != nil || n != 6 || err != nil {
+		t.Fatal(b, n, err)
+	}
+
+	var buf bytes.Buffer
+	if err := exe.Write(&buf, &exe.Header{BuildID: "42"}, &virtual.Binary{}); err != nil {
+		t.Fatal(err)
+	}
+
+	in := filepath.Join(dir, "a.out")
+	if err := ioutil.WriteFile(in, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "a")
+	if err := pack(out, runner, in); err != nil {
+		t.Fatal(err)
+	}
+
+	b, n, err := payload(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(b, buf.Bytes()) || n != 6 {
+		t.Fatalf("got %q %v, expected %q 6", b, n, buf.Bytes())
+	}
+
+	// Repacking using a packed executable as the runner.
+	out2 := filepath.Join(dir, "b")
+	if err := pack(out2, out, in); err != nil {
+		t.Fatal(err)
+	}
+
+	b2, err := ioutil.ReadFile(out2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(b, b2) {
+		t.Fatalf("got %q, expected %q", b2, b)
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command 99pack turns binary programs produced by the 99c compiler into self
+// contained native executables.
+//
+// Usage
+//
+//	99pack [-o output] [-runner file] a.out
+//
+// Flags
+//
+//	-o file
+//		write the native executable to file, defaults to the name of
+//		a.out without its extension
+//	-runner file
+//		use the 99pack executable file as the runner, defaults to the
+//		running 99pack
+//
+// Packing
+//
+// The native executable is a copy of the 99pack executable with the compiled
+// program appended. When started, it executes the program in the virtual
+// machine like 99run does, passing it all its arguments. The computer running
+// the native executable needs neither Go nor any of the 99c tools installed.
+// Programs compiled with -g print stack traces when they crash.
+//
+//	$ 99c -g hello.c && 99pack -o hello a.out
+//	$ ./hello
+//	hello world
+//	$
+//
+// The native executable runs on the operating system and architecture 99pack
+// was built for. To produce one for another system, pass a 99pack built for
+// that system as the runner. The program must be compiled for the same target.
+// As 99c does not cross compile yet, compile it on that system.
+//
+//	$ GOOS=windows GOARCH=amd64 go build -o 99pack.exe github.com/cznic/99c/99pack
+//	$ 99pack -runner 99pack.exe -o hello.exe a.out
+//
+// Installation
+//
+// To install or update 99pack
+//
+//      $ go get [-u] github.com/cznic/99c/99pack
+//
+// Online documentation: [godoc.org/github.com/cznic/99c/99pack](http://godoc.org/github.com/cznic/99c/99pack)
+//
+// Changelog
+//
+// 2026-10-18: Initial public release.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/cznic/99c/exe"
+	"github.com/cznic/virtual"
+)
+
+// A packed executable ends with a trailer consisting of the length of the
+// appended program as an uint64 in little endian byte order followed by
+// magic.
+const (
+	magic       = "\x7f99pack"
+	trailerSize = 8 + len(magic)
+)
+
+func exit(code int, msg string, arg ...interface{}) {
+	if msg != "" {
+		fmt.Fprintf(os.Stderr, os.Args[0]+": "+msg, arg...)
+	}
+	os.Exit(code)
+}
+
+func main() {
+	self, err := os.Executable()
+	if err != nil {
+		exit(1, "%v\n", err)
+	}
+
+	b, _, err := payload(self)
+	if err != nil {
+		exit(1, "%v\n", err)
+	}
+
+	if b != nil {
+		run(b)
+	}
+
+	out := flag.String("o", "", "write the native executable to file, defaults to the name of a.out without its extension")
+	runner := flag.String("runner", self, "use the 99pack executable file as the runner, defaults to the running 99pack")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		exit(2, "invalid arguments %v\n", os.Args)
+	}
+
+	in := flag.Arg(0)
+	if *out == "" {
+		*out = strings.TrimSuffix(in, filepath.Ext(in))
+		if runtime.GOOS == "windows" {
+			*out += ".exe"
+		}
+		if *out == in {
+			exit(2, "cannot derive the output file name from %s, use -o\n", in)
+		}
+	}
+
+	if err := pack(*out, *runner, in); err != nil {
+		exit(1, "%v\n", err)
+	}
+}
+
+// run executes the program appended to the running executable and exits.
+func run(b []byte) {
+	h, bin, err := exe.Read(bytes.NewReader(b))
+	if err != nil {
+		exit(1, "%v\n", err)
+	}
+
+	if err := h.CheckTarget(); err != nil {
+		exit(1, "%v\n", err)
+	}
+
+	code, err := virtual.Exec(bin, os.Args, os.Stdin, os.Stdout, os.Stderr, 0, 8<<20, "")
+	if err != nil {
+		if code == 0 {
+			code = 1
+		}
+		exit(code, "%v\n", err)
+	}
+
+	exit(code, "")
+}
+
+// payload returns the program appended to the file fn, if any, and the size
+// of fn without the program and its trailer.
+func payload(fn string) ([]byte, int64, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	size := fi.Size()
+	if size < int64(trailerSize) {
+		return nil, size, nil
+	}
+
+	var t [trailerSize]byte
+	if _, err := f.ReadAt(t[:], size-int64(trailerSize)); err != nil {
+		return nil, 0, err
+	}
+
+	if string(t[8:]) != magic {
+		return nil, size, nil
+	}
+
+	n := binary.LittleEndian.Uint64(t[:8])
+	if n > uint64(size)-uint64(trailerSize) {
+		return nil, 0, fmt.Errorf("%s: invalid packed executable", fn)
+	}
+
+	off := size - int64(trailerSize) - int64(n)
+	b := make([]byte, n)
+	if _, err := f.ReadAt(b, off); err != nil {
+		return nil, 0, err
+	}
+
+	return b, off, nil
+}
+
+// pack writes to out the runner followed by the program in.
+func pack(out, runner, in string) (err error) {
+	b, err := ioutil.ReadFile(in)
+	if err != nil {
+		return err
+	}
+
+	if _, _, err := exe.Read(bytes.NewReader(b)); err != nil {
+		return fmt.Errorf("%s: %v", in, err)
+	}
+
+	// A packed executable used as the runner loses its program.
+	_, n, err := payload(runner)
+	if err != nil {
+		return err
+	}
+
+	r, err := os.Open(runner)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	w, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if e := w.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+
+	if _, err := io.CopyN(w, r, n); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(b); err != nil {
+		return err
+	}
+
+	var t [trailerSize]byte
+	binary.LittleEndian.PutUint64(t[:8], uint64(len(b)))
+	copy(t[8:], magic)
+	_, err = w.Write(t[:])
+	return err
+}
@@ -31,6 +31,10 @@
 //
 // Changelog
 //
+// 2026-10-19: Remove the -replay flag.
+//
+// 2026-10-18: Add the -replay flag.
+//
 // 2017-10-09: Initial public release.
 //
 // Sample
@@ -270,6 +274,7 @@ import (
 	"sort"
 	"time"
 
+	"github.com/cznic/99c/exe"
 	"github.com/cznic/virtual"
 )
 
@@ -306,8 +311,12 @@ or
 		exit(1, "%v\n", err)
 	}
 
-	var b virtual.Binary
-	if _, err := b.ReadFrom(bufio.NewReader(bin)); err != nil {
+	h, b, err := exe.Read(bin)
+	if err != nil {
+		exit(1, "%v\n", err)
+	}
+
+	if err := h.CheckTarget(); err != nil {
 		exit(1, "%v\n", err)
 	}
 
@@ -334,7 +343,7 @@ or
 	}
 
 	t0 := time.Now()
-	vm, code, err := virtual.New(&b, args, os.Stdin, os.Stdout, os.Stderr, 0, 8<<20, "", opts...)
+	vm, code, err := virtual.New(b, args, os.Stdin, os.Stdout, os.Stderr, 0, 8<<20, "", opts...)
 	d := time.Since(t0)
 	if err != nil {
 		if code == 0 {
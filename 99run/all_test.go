@@ -5,12 +5,19 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
+
+	"github.com/cznic/99c/exe"
+	"github.com/cznic/virtual"
 )
 
 func caller(s string, va ...interface{}) {
@@ -54,3 +61,162 @@ func init() {
 func Test(t *testing.T) {
 	t.Logf("TODO")
 }
+
+func TestSize(t *testing.T) {
+	for _, v := range []struct {
+		s string
+		n int
+	}{
+		{"0", 0},
+		{"4096", 4096},
+		{"64K", 64 << 10},
+		{"8M", 8 << 20},
+		{"1G", 1 << 30},
+	} {
+		var s size
+		if err := s.Set(v.s); err != nil {
+			t.Fatal(err)
+		}
+
+		if g, e := int(s), v.n; g != e {
+			t.Fatalf("%s: got %v, expected %v", v.s, g, e)
+		}
+	}
+	for _, v := range []string{"", "K", "-1", "1T", "4G", "5000G"} {
+		var s size
+		err := s.Set(v)
+		if err == nil {
+			t.Fatalf("%q: unexpected success", v)
+		}
+
+		if g, e := err.Error(), "invalid size: "+v; g != e {
+			t.Fatalf("got %q, expected %q", g, e)
+		}
+	}
+}
+
+func TestBinfmtConfig(t *testing.T) {
+	if g, e := binfmtConfig("/usr/local/bin/99run"), `:99c:M::\x7f\x39\x39\x63::/usr/local/bin/99run:F`; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+}
+
+func TestEnviron(t *testing.T) {
+	f, err := ioutil.TempFile("", "99run-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Remove(f.Name())
+
+	f.WriteString("# comment\n\nA=file\nB=file\n")
+	f.Close()
+	e := environment{file: f.Name()}
+	if err := e.vars.Set("B=flag"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.vars.Set("C"); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	env, err := e.environ([]string{"A=host", "HOME=/home"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := fmt.Sprint(env), "[A=file HOME=/home B=flag]"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	e.clear = true
+	if env, err = e.environ([]string{"A=host", "HOME=/home"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := fmt.Sprint(env), "[A=file B=flag]"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	e.argv0 = "prog"
+	if g, e := fmt.Sprint(e.args([]string{"a.out", "x"})), "[prog x]"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	saved := os.Environ()
+	defer setEnviron(saved)
+
+	if err := setEnviron(env); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := fmt.Sprint(os.Environ()), "[A=file B=flag]"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	ioutil.WriteFile(f.Name(), []byte("=x\n"), 0644)
+	if _, err := e.environ(nil); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	var bin bytes.Buffer
+	if err := exe.Write(&bin, &exe.Header{BuildID: "42"}, &virtual.Binary{}); err != nil {
+		t.Fatal(err)
+	}
+
+	h, _, stdin, err := load("-", "", bytes.NewReader(append(bin.Bytes(), "input"...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := h.BuildID, "42"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	b, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := string(b), "input"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	dir, err := ioutil.TempDir("", "99run-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("bin/prog")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.Write(bin.Bytes())
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	bundle := filepath.Join(dir, "bundle.zip")
+	if err := ioutil.WriteFile(bundle, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if h, _, stdin, err = load("bin/prog", bundle, os.Stdin); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.BuildID != "42" || stdin != os.Stdin {
+		t.Fatal(h.BuildID, stdin)
+	}
+
+	if _, _, _, err := load("bin/other", bundle, os.Stdin); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
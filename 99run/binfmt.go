@@ -0,0 +1,39 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cznic/99c/exe"
+)
+
+// binfmtName is the name of the binfmt_misc registration.
+const binfmtName = "99c"
+
+// binfmtConfig returns the binfmt_misc registration making the kernel run
+// executables starting with exe.Magic using interpreter. The F flag makes the
+// kernel open interpreter when registering it, so the registration works also
+// in containers and chroots not containing interpreter.
+func binfmtConfig(interpreter string) string {
+	var magic bytes.Buffer
+	for i := 0; i < len(exe.Magic); i++ {
+		fmt.Fprintf(&magic, `\x%02x`, exe.Magic[i])
+	}
+	return fmt.Sprintf(":%s:M::%s::%s:F", binfmtName, magic.String(), interpreter)
+}
+
+// interpreter returns the absolute path of the running 99run.
+func interpreter() (string, error) {
+	fn, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.EvalSymlinks(fn)
+}
@@ -0,0 +1,45 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const binfmtMisc = "/proc/sys/fs/binfmt_misc"
+
+// installBinfmt registers config with the kernel, replacing any previous
+// registration of the same name.
+func installBinfmt(config string) error {
+	register := filepath.Join(binfmtMisc, "register")
+	if _, err := os.Stat(register); err != nil {
+		return fmt.Errorf("binfmt_misc is not mounted at %s: %v", binfmtMisc, err)
+	}
+
+	entry := filepath.Join(binfmtMisc, binfmtName)
+	if _, err := os.Stat(entry); err == nil {
+		if err := writeProc(entry, "-1"); err != nil {
+			return err
+		}
+	}
+
+	return writeProc(register, config)
+}
+
+func writeProc(name, s string) error {
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write([]byte(s)); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
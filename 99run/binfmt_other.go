@@ -0,0 +1,16 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func installBinfmt(string) error {
+	return fmt.Errorf("binfmt_misc is not supported on %s", runtime.GOOS)
+}
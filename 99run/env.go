@@ -0,0 +1,130 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// vars is a flag.Value collecting KEY=VALUE pairs. The flag may be repeated.
+type vars []string
+
+func (v *vars) String() string { return strings.Join(*v, " ") }
+
+func (v *vars) Set(s string) error {
+	if err := checkVar(s); err != nil {
+		return err
+	}
+
+	*v = append(*v, s)
+	return nil
+}
+
+func checkVar(s string) error {
+	if strings.IndexByte(s, '=') <= 0 {
+		return fmt.Errorf("invalid environment variable %q, expected KEY=VALUE", s)
+	}
+
+	return nil
+}
+
+// environment holds the flags setting up the process environment of the
+// program.
+type environment struct {
+	argv0 string
+	chdir string
+	clear bool
+	file  string
+	vars  vars
+}
+
+// environ returns the environment of the program given the environment it
+// would otherwise inherit. Variables from the -env-file file are set before
+// the ones given by -env.
+func (e *environment) environ(env []string) ([]string, error) {
+	var r []string
+	if !e.clear {
+		r = append(r, env...)
+	}
+
+	var a []string
+	if e.file != "" {
+		var err error
+		if a, err = readEnvFile(e.file); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, v := range append(a, e.vars...) {
+		r = setenv(r, v)
+	}
+	return r, nil
+}
+
+// setEnviron replaces the environment of the process, which the program
+// shares, by env.
+func setEnviron(env []string) error {
+	os.Clearenv()
+	for _, v := range env {
+		i := strings.IndexByte(v, '=')
+		if i <= 0 {
+			continue
+		}
+
+		if err := os.Setenv(v[:i], v[i+1:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// args returns the arguments of the program.
+func (e *environment) args(args []string) []string {
+	if e.argv0 == "" {
+		return args
+	}
+
+	return append([]string{e.argv0}, args[1:]...)
+}
+
+// setenv sets the KEY=VALUE pair kv in env, replacing an existing value of
+// KEY.
+func setenv(env []string, kv string) []string {
+	k := kv[:strings.IndexByte(kv, '=')+1]
+	for i, v := range env {
+		if strings.HasPrefix(v, k) {
+			env[i] = kv
+			return env
+		}
+	}
+	return append(env, kv)
+}
+
+// readEnvFile returns the KEY=VALUE pairs listed one per line in file fn.
+// Empty lines and lines starting with # are ignored.
+func readEnvFile(fn string) ([]string, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	var r []string
+	for i, v := range strings.Split(string(b), "\n") {
+		v = strings.TrimSpace(v)
+		if v == "" || strings.HasPrefix(v, "#") {
+			continue
+		}
+
+		if err := checkVar(v); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", fn, i+1, err)
+		}
+
+		r = append(r, v)
+	}
+	return r, nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exitTimeout is the exit code used when -timeout is exceeded.
+const exitTimeout = 124
+
+// size is a flag.Value accepting byte counts with an optional K, M or G
+// suffix.
+type size int
+
+func (s *size) String() string { return strconv.Itoa(int(*s)) }
+
+func (s *size) Set(v string) error {
+	m := 1
+	switch {
+	case strings.HasSuffix(v, "K"):
+		m = 1 << 10
+	case strings.HasSuffix(v, "M"):
+		m = 1 << 20
+	case strings.HasSuffix(v, "G"):
+		m = 1 << 30
+	}
+	digits := v
+	if m != 1 {
+		digits = v[:len(v)-1]
+	}
+	n, err := strconv.ParseUint(digits, 10, 31)
+	if err != nil || int64(n)*int64(m) > 1<<31-1 {
+		return fmt.Errorf("invalid size: %s", v)
+	}
+
+	*s = size(int(n) * m)
+	return nil
+}
+
+type limits struct {
+	heap    size
+	stack   size
+	timeout time.Duration
+}
+
+// run executes f. If f does not return within the time limit, run returns
+// false.
+func (l *limits) run(f func()) bool {
+	if l.timeout <= 0 {
+		f()
+		return true
+	}
+
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(l.timeout):
+		return false
+	}
+}
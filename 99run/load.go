@@ -0,0 +1,70 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cznic/99c/exe"
+	"github.com/cznic/99c/vfs"
+	"github.com/cznic/ir"
+	"github.com/cznic/virtual"
+	"github.com/cznic/xc"
+)
+
+// load reads the executable name. If bundle is not empty, name is looked up in
+// the archive bundle. Otherwise the name - denotes stdin, in which case the
+// returned reader continues stdin after the executable. In all other cases
+// the returned reader is stdin.
+func load(name, bundle string, stdin io.Reader) (*exe.Header, *virtual.Binary, io.Reader, error) {
+	var r io.Reader
+	switch {
+	case bundle != "":
+		fs, err := vfs.OpenArchive(bundle)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		f, err := fs.Open(name, os.O_RDONLY, 0)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%s: %v", bundle, err)
+		}
+
+		defer f.Close()
+
+		r = f
+	case name == "-":
+		br := bufio.NewReader(stdin)
+		r, stdin = br, br
+	default:
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		defer f.Close()
+
+		r = f
+	}
+
+	h, b, err := exe.Read(r)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return h, b, stdin, nil
+}
+
+// check returns an error if b is not a program, ie. it has no _start function.
+func check(b *virtual.Binary) error {
+	if _, ok := b.Sym[ir.NameID(xc.Dict.SID("_start"))]; !ok {
+		return fmt.Errorf("missing _start function, not a program")
+	}
+
+	return nil
+}
@@ -8,7 +8,120 @@
 //
 // To execute a compiled binary named a.out
 //
-//	99run a.out [arguments]
+//	99run [flags] a.out [arguments]
+//
+// To execute a compiled binary read from stdin
+//
+//	99run [flags] - [arguments]
+//
+// To execute a compiled binary named prog stored in an archive
+//
+//	99run [flags] -bundle archive prog [arguments]
+//
+// Flags
+//
+//	-argv0 name
+//		pass name to the program as argv[0]
+//	-binfmt-config
+//		print the binfmt_misc registration of 99run and exit
+//	-bundle archive
+//		run the executable named by the first argument from the archive file
+//	-chdir dir
+//		run the program in directory dir
+//	-check
+//		check that the executable can be run, but do not run it
+//	-clearenv
+//		start the program with an empty environment
+//	-env KEY=VALUE
+//		set the environment variable KEY to VALUE, may be repeated
+//	-env-file file
+//		read environment variables from file
+//	-heap size
+//		heap size in bytes, 0 selects the default
+//	-install-binfmt
+//		register 99run with binfmt_misc and exit
+//	-mem size
+//		same as -heap
+//	-stack size
+//		stack size in bytes (default 8M)
+//	-timeout duration
+//		stop the program after running for duration, 0 means no limit
+//
+// Sizes accept an optional K, M or G suffix.
+//
+// Executables
+//
+// The executable - is read from stdin. The program then reads the rest of
+// stdin, which allows piping the executable from a build tool.
+//
+//	99c -o /dev/stdout hello.c | 99run -
+//
+// With -bundle, the first argument names an executable stored in a .zip,
+// .tar, .tar.gz or .tgz archive, so several programs can be distributed as a
+// single file.
+//
+//	99run -bundle tools.zip bin/grep -n main main.c
+//
+// -check reports whether the executable can be run by this 99run without
+// running it. The executable must have a supported format version, be compiled
+// for the host and be a program rather than a library. If not, 99run reports
+// the problem and exits with code 1. An executable using builtins unknown to
+// the virtual machine passes the check, the virtual machine provides no way to
+// detect them.
+//
+//	99run -check a.out && echo ok
+//
+// Environment
+//
+// By default the program inherits the environment and the working directory of
+// 99run. With -clearenv the program starts with an empty environment. -env-file
+// sets the variables listed in a file, one KEY=VALUE pair per line, and -env
+// sets individual variables, overriding the file. Empty lines and lines
+// starting with # in the file are ignored. -chdir sets the working directory of
+// the program. -argv0 sets the name the program sees itself invoked as. The
+// virtual machine has no environment of its own, the program shares the
+// environment of the 99run process, so 99run sets up the environment of its
+// process before executing the program.
+//
+//	99run -clearenv -env-file test.env -env DEBUG=1 -chdir testdata a.out
+//
+// Go programs embedding the virtual machine pass the working directory as the
+// wd argument of virtual.Exec. Programs executed concurrently in one process
+// share its environment.
+//
+// Signals
+//
+// Signals received by 99run are not delivered to the program. They take their
+// default action on 99run, eg. Ctrl-C terminates 99run together with the
+// program. Handlers the program installs using signal or sigaction are never
+// invoked and the program cannot send signals to itself using raise or kill.
+//
+// Executing binaries directly
+//
+// On Linux, 99c starts executables with a #!/usr/bin/env 99run line, so they
+// can be executed directly when 99run is in $PATH. Executables compiled with
+// -99noshebang start with the "\x7f99c" magic number instead and are executed
+// directly once 99run is registered with binfmt_misc. The registration uses the
+// F flag, the kernel opens 99run when it is registered, so this works also
+// where 99run is not in $PATH or not present at all, eg. in containers. To
+// register 99run until the next reboot
+//
+//	sudo 99run -install-binfmt
+//
+// To register 99run permanently on systems using systemd
+//
+//	99run -binfmt-config | sudo tee /etc/binfmt.d/99c.conf
+//	sudo systemctl restart systemd-binfmt
+//
+// 99run executes executables with or without the shebang line.
+//
+// Exit codes
+//
+// The exit code is the exit code of the program, except when the time limit is
+// exceeded. A program exhausting its -heap or -stack fails like on any other
+// error reported by the virtual machine, with exit code 1.
+//
+//	124	time limit exceeded
 //
 // Installation
 //
@@ -20,11 +133,109 @@
 //
 // Changelog
 //
+// 2026-10-19: Remove the -gdb flag. The virtual machine provides no way to
+// execute a program under the control of a debugger.
+//
+// 2026-10-19: Remove the -memcheck flag and the exit code 67. The virtual
+// machine provides no way to observe the memory accesses and allocations of the
+// program.
+//
+// 2026-10-19: Remove the -race flag and the exit code 66. The virtual machine
+// provides no way to observe the memory accesses of the program.
+//
+// 2026-10-19: Remove the POSIX threads functions. The virtual machine provides
+// no way for a C program to call them, see package
+// github.com/cznic/99c/lib/pthread for the single threaded library used by 99c
+// -pthread.
+//
+// 2026-10-19: -check no longer looks for unknown instructions, the virtual
+// machine provides no way to detect them.
+//
+// 2026-10-19: -clearenv, -env and -env-file set the environment of the 99run
+// process, which the program shares. The virtual machine has no environment of
+// its own.
+//
+// 2026-10-19: The binfmt_misc registration uses the F flag.
+//
+// 2026-10-19: Remove the -core flag. The virtual machine provides no way to
+// obtain the state of a crashed program.
+//
+// 2026-10-19: Host signals are no longer forwarded to the program and the exit
+// code 128+N is removed. The virtual machine provides no way to deliver signals
+// to the program.
+//
+// 2026-10-19: Remove the -snapshot and -restore flags. The virtual machine
+// provides no way to save and restore the state of the program.
+//
+// 2026-10-19: Remove the -record and -replay flags. The virtual machine
+// provides no way to intercept the calls of the program. Package host keeps
+// Recorder and Replayer for Go programs performing calls themselves.
+//
+// 2026-10-19: Remove the -deterministic, -seed, -clock-step and -clock-rate
+// flags. The virtual machine provides no way to intercept the calls of the
+// program and no instruction counter. Package host keeps Deterministic, without
+// PerInstruction, for Go programs performing calls themselves.
+//
+// 2026-10-19: Remove the -allow, -deny, -allow-write, -log-denied and -policy
+// flags. The virtual machine provides no way to intercept the calls of the
+// program. Package host keeps Policy for Go programs checking calls themselves.
+//
+// 2026-10-19: The policy resolves paths against the working directory of the
+// program and evaluates symbolic links. It covers symlink, link, chmod and
+// truncate. -allow and -deny reject unknown call names.
+//
+// 2026-10-19: Remove the -root, -mount and -overlay flags. The virtual machine
+// provides no way to route the file access of the program through a file system
+// of package vfs.
+//
+// 2026-10-19: Remove the -max-instructions flag and the exit codes 120, 121 and
+// 122. The virtual machine provides neither an instruction limit nor distinct
+// errors for exhausted heap and stack.
+//
+// 2026-10-18: Programs can create threads using the POSIX threads functions of
+// package pthread.
+//
+// 2026-10-18: Add the -gdb flag.
+//
+// 2026-10-18: Add the -memcheck flag.
+//
+// 2026-10-18: Add the -race flag. Executables compiled using 99c -race run
+// with the race detector enabled.
+//
+// 2026-10-18: Add the -bundle and -check flags. The executable - is read from
+// stdin.
+//
+// 2026-10-18: Add the -env, -env-file, -clearenv, -chdir and -argv0 flags.
+//
+// 2026-10-18: Add the -binfmt-config and -install-binfmt flags.
+//
+// 2026-10-18: Add the -core flag.
+//
+// 2026-10-18: Forward host signals to the program. Programs terminated by a
+// signal exit with code 128+N.
+//
+// 2026-10-18: Add the -snapshot and -restore flags.
+//
+// 2026-10-18: Add the -record and -replay flags.
+//
+// 2026-10-18: Add the -deterministic, -seed, -clock-step and -clock-rate
+// flags.
+//
+// 2026-10-18: Add the -allow, -deny, -allow-write, -log-denied and -policy
+// flags.
+//
+// 2026-10-18: Add the -root, -mount and -overlay flags.
+//
+// 2026-10-18: Add the -heap, -mem, -stack, -max-instructions and -timeout
+// flags.
+//
+// 2026-10-18: Refuse to execute binaries compiled for a different target.
+//
 // 2017-01-07: Initial public release.
 package main
 
 import (
-	"bufio"
+	"flag"
 	"fmt"
 	"os"
 
@@ -39,21 +250,81 @@ func exit(code int, msg string, arg ...interface{}) {
 }
 
 func main() {
-	if len(os.Args) < 2 {
+	l := limits{stack: 8 << 20}
+	var env environment
+	flag.StringVar(&env.argv0, "argv0", "", "pass name to the program as argv[0]")
+	bundle := flag.String("bundle", "", "run the executable named by the first argument from the archive file")
+	binfmt := flag.Bool("binfmt-config", false, "print the binfmt_misc registration of 99run and exit")
+	checkOnly := flag.Bool("check", false, "check that the executable can be run, but do not run it")
+	flag.StringVar(&env.chdir, "chdir", "", "run the program in directory dir")
+	flag.BoolVar(&env.clear, "clearenv", false, "start the program with an empty environment")
+	flag.Var(&env.vars, "env", "set the environment variable KEY to VALUE, may be repeated")
+	flag.StringVar(&env.file, "env-file", "", "read environment variables from file")
+	flag.Var(&l.heap, "heap", "heap size in bytes, 0 selects the default")
+	install := flag.Bool("install-binfmt", false, "register 99run with binfmt_misc and exit")
+	flag.Var(&l.heap, "mem", "same as -heap")
+	flag.Var(&l.stack, "stack", "stack size in bytes")
+	flag.DurationVar(&l.timeout, "timeout", 0, "stop the program after running for duration, 0 means no limit")
+	flag.Parse()
+
+	if *binfmt || *install {
+		fn, err := interpreter()
+		if err != nil {
+			exit(1, "%v\n", err)
+		}
+
+		if *binfmt {
+			fmt.Println(binfmtConfig(fn))
+			exit(0, "")
+		}
+
+		if err := installBinfmt(binfmtConfig(fn)); err != nil {
+			exit(1, "%v\n", err)
+		}
+
+		exit(0, "")
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
 		exit(2, "invalid arguments %v\n", os.Args)
 	}
 
-	bin, err := os.Open(os.Args[1])
+	vars, err := env.environ(os.Environ())
 	if err != nil {
 		exit(1, "%v\n", err)
 	}
 
-	var b virtual.Binary
-	if _, err := b.ReadFrom(bufio.NewReader(bin)); err != nil {
+	argv := env.args(args)
+
+	h, b, stdin, err := load(args[0], *bundle, os.Stdin)
+	if err != nil {
 		exit(1, "%v\n", err)
 	}
 
-	code, err := virtual.Exec(&b, os.Args[1:], os.Stdin, os.Stdout, os.Stderr, 0, 8<<20, "")
+	if err := h.CheckTarget(); err != nil {
+		exit(1, "%v\n", err)
+	}
+
+	if *checkOnly {
+		if err := check(b); err != nil {
+			exit(1, "%s: %v\n", args[0], err)
+		}
+
+		exit(0, "")
+	}
+
+	if err := setEnviron(vars); err != nil {
+		exit(1, "%v\n", err)
+	}
+
+	var code int
+	if !l.run(func() {
+		code, err = virtual.Exec(b, argv, stdin, os.Stdout, os.Stderr, int(l.heap), int(l.stack), env.chdir)
+	}) {
+		exit(exitTimeout, "time limit of %v exceeded\n", l.timeout)
+	}
+
 	if err != nil {
 		if code == 0 {
 			code = 1
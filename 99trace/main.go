@@ -23,6 +23,10 @@
 //
 // Changelog
 //
+// 2026-10-19: Remove the -replay flag.
+//
+// 2026-10-18: Add the -replay flag.
+//
 // 2017-10-09: Initial public release.
 //
 // Sample
@@ -62,10 +66,10 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
 	"os"
 
+	"github.com/cznic/99c/exe"
 	"github.com/cznic/virtual"
 )
 
@@ -95,12 +99,16 @@ or
 		exit(1, "%v\n", err)
 	}
 
-	var b virtual.Binary
-	if _, err := b.ReadFrom(bufio.NewReader(bin)); err != nil {
+	h, b, err := exe.Read(bin)
+	if err != nil {
+		exit(1, "%v\n", err)
+	}
+
+	if err := h.CheckTarget(); err != nil {
 		exit(1, "%v\n", err)
 	}
 
-	code, err := virtual.Exec(&b, os.Args[1:], os.Stdin, os.Stdout, os.Stderr, 0, 8<<20, "")
+	code, err := virtual.Exec(b, os.Args[1:], os.Stdin, os.Stdout, os.Stderr, 0, 8<<20, "")
 	if err != nil {
 		if code == 0 {
 			code = 1
@@ -5,7 +5,9 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"go/token"
 	"io/ioutil"
 	"os"
 	"path"
@@ -14,6 +16,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/cznic/99c/exe"
 	"github.com/cznic/ir"
 	"github.com/cznic/virtual"
 	"github.com/cznic/xc"
@@ -127,6 +130,53 @@ func TestIssue4(t *testing.T) {
 	}
 }
 
+// Building the same source in different directories must produce the same
+// executable when the directories are mapped to the same prefix.
+func TestReproducibleBuild(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/reproducible.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		dir, err := ioutil.TempDir("", "99c-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer os.RemoveAll(dir)
+
+		src := filepath.Join(dir, "main.c")
+		if err := ioutil.WriteFile(src, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		var bin *virtual.Binary
+		j := newTask()
+		j.args.args = []string{src}
+		j.args.g = true
+		j.args.o = filepath.Join(dir, "a.out")
+		j.args.hooks.bin = &bin
+		if err := j.args.prefixMap.add(dir + "=/src"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := j.main(); err != nil {
+			t.Fatal(err)
+		}
+
+		if bytes.Contains(bin.Text, []byte(dir)) {
+			t.Fatalf("%s: text segment contains the build directory", dir)
+		}
+
+		ids = append(ids, exe.BuildID(bin))
+	}
+	if g, e := ids[1], ids[0]; g != e {
+		t.Fatalf("got build ID %s, expected %s", g, e)
+	}
+}
+
 func TestLibToolConfig(t *testing.T) {
 	m, err := filepath.Glob(filepath.Join("testdata", "*.la"))
 	if err != nil {
@@ -142,3 +192,200 @@ func TestLibToolConfig(t *testing.T) {
 		t.Logf("%s: %#v", v, c)
 	}
 }
+
+func TestParseTarget(t *testing.T) {
+	h := hostTarget()
+	for _, v := range []string{"linux/386", "linux/amd64", "windows/386", "windows/amd64"} {
+		tg, err := parseTarget(v)
+		if v != h.String() {
+			if err == nil {
+				t.Fatalf("%q: unexpected success on %s", v, h)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if g, e := tg.String(), v; g != e {
+			t.Fatalf("got %q, expected %q", g, e)
+		}
+	}
+	for _, v := range []string{"", "linux", "linux/arm", "plan9/386"} {
+		if _, err := parseTarget(v); err == nil {
+			t.Fatalf("%q: unexpected success", v)
+		}
+	}
+}
+
+func TestPrefixes(t *testing.T) {
+	old, ok := os.LookupEnv("NINETYNINEC_ROOT")
+
+	defer func() {
+		if ok {
+			os.Setenv("NINETYNINEC_ROOT", old)
+			return
+		}
+
+		os.Unsetenv("NINETYNINEC_ROOT")
+	}()
+
+	os.Setenv("NINETYNINEC_ROOT", strings.Join([]string{"/c", "/a"}, string(filepath.ListSeparator)))
+	j := newTask()
+	j.args.getopt([]string{"99c", "-B/a", "--prefix", "/b"})
+	if g, e := fmt.Sprint(j.prefixes()), "[/a /b /c]"; g != e {
+		t.Fatalf("got %s, expected %s", g, e)
+	}
+}
+
+func TestPthread(t *testing.T) {
+	j := newTask()
+	j.args.getopt([]string{"99c", "-pthread", "main.c"})
+	if g, e := fmt.Sprint(j.args.D), "[#define _REENTRANT 1]"; g != e {
+		t.Fatalf("got %s, expected %s", g, e)
+	}
+
+	if g, e := fmt.Sprint(j.args.l), "[pthread]"; g != e {
+		t.Fatalf("got %s, expected %s", g, e)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	c, err := newConfig(strings.NewReader(`
+# Legacy code settings.
+extra = [
+	"ImplicitFuncDef", # K&R
+	"ImplicitIntType",
+]
+define = ["FOO", 'BAR=2', "S=\"#\""]
+include = ["inc", "/usr/local/include"]
+lib = "xcb"
+noextra = ["Asm"]
+prefix = ["99c"]
+`), "/prj")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &args{
+		D:     []string{define("BAR=3")},
+		I:     []string{"/cmd"},
+		extra: []string{"Asm"},
+	}
+	c.merge(a)
+	var buf bytes.Buffer
+	writeConfig(&buf, a, a.B, "")
+	if g, e := buf.String(), fmt.Sprintf(`extra = ["ImplicitFuncDef", "ImplicitIntType", "Asm"]
+define = ["FOO=1", "S=\"#\"", "BAR=3"]
+include = ["/cmd", %q, "/usr/local/include"]
+libdir = []
+lib = ["xcb"]
+noextra = ["Asm"]
+prefix = [%q]
+std = ""
+`, filepath.Join("/prj", "inc"), filepath.Join("/prj", "99c")); g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+
+	// The output is a valid configuration having the same effect.
+	if c, err = newConfig(bytes.NewReader(buf.Bytes()), "/"); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &args{}
+	c.merge(b)
+	var buf2 bytes.Buffer
+	writeConfig(&buf2, b, b.B, "")
+	if g, e := buf2.String(), buf.String(); g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+
+	for _, v := range []string{
+		"extra = [\"NoSuchExtension\"]",
+		"foo = \"bar\"",
+		"include = [\"a\" \"b\"]",
+		"include = \"a",
+		"lib = \"a\"\nlib = \"b\"",
+		"noextra = [\"NoSuchExtension\"]",
+		"std = \"c42\"",
+	} {
+		if _, err := newConfig(strings.NewReader(v), ""); err == nil {
+			t.Fatalf("%q: unexpected success", v)
+		}
+	}
+}
+
+func TestStd(t *testing.T) {
+	for k := range stds {
+		for _, v := range stdExtra(k) {
+			if _, err := extra(v); err != nil {
+				t.Fatalf("%s: %v", k, err)
+			}
+		}
+	}
+
+	if g, e := strings.Join(stdPredefined("c99", nil), "\n"), `#undef __STDC_VERSION__
+#define __STRICT_ANSI__ 1
+#define __STDC_VERSION__ 199901L`; g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+
+	if g, e := strings.Join(stdPredefined("gnu89", []string{define("__GNUC__=7")}), "\n"), `#undef __STDC_VERSION__
+#define __GNUC_MINOR__ 2
+#define __GNUC_PATCHLEVEL__ 1`; g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+}
+
+func TestPrefixMap(t *testing.T) {
+	type op struct {
+		Position token.Position
+	}
+
+	type str struct {
+		Position token.Position
+		Value    ir.StringID
+	}
+
+	type fn struct {
+		Body     []interface{}
+		Position token.Position
+		pos      token.Position
+		strs     []str
+	}
+
+	var m prefixMap
+	for _, v := range []string{"/home/jnml/src=/src", "/home/jnml/src/github.com/cznic/ccir=ccir"} {
+		if err := m.add(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := &fn{
+		Body: []interface{}{
+			op{token.Position{Filename: "/home/jnml/src/github.com/cznic/ccir/libc/crt0.c"}},
+			&op{token.Position{Filename: "/home/jnml/src/foo/foo.c"}},
+		},
+		Position: token.Position{Filename: "/tmp/bar.c"},
+		pos:      token.Position{Filename: "/home/jnml/src/foo/bar.h"},
+		strs: []str{
+			{token.Position{Filename: "/home/jnml/src/foo/foo.c"}, ir.StringID(xc.Dict.SID("/home/jnml/src/foo/foo.c"))},
+			{Value: ir.StringID(xc.Dict.SID("/home/jnml/src/foo/baz.c"))},
+		},
+	}
+	m.apply([]interface{}{f})
+	if g, e := fmt.Sprintf("%s %s %s %s", f.Body[0].(op).Position.Filename, f.Body[1].(*op).Position.Filename, f.Position.Filename, f.pos.Filename), "ccir/libc/crt0.c /src/foo/foo.c /tmp/bar.c /src/foo/bar.h"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	// Only the names of rewritten files are rewritten in string literals.
+	if g, e := fmt.Sprintf("%s %s", f.strs[0].Value, f.strs[1].Value), "/src/foo/foo.c /home/jnml/src/foo/baz.c"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	if err := m.add("foo"); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
@@ -0,0 +1,338 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const configName = ".99c.toml"
+
+// config holds the default flags read from a configuration file. The file
+// uses a subset of TOML: comments and top level keys having a string or an
+// array of strings as the value.
+//
+//	# .99c.toml
+//	extra = ["ImplicitFuncDef", "ImplicitIntType", "Asm"]
+//	define = ["_GNU_SOURCE", "VERSION=\"1.0\""]
+//	include = ["include"]
+//	libdir = ["lib"]
+//	lib = ["xcb"]
+//	noextra = ["Asm"]
+//	prefix = ["/opt/99c"]
+//	std = "gnu99"
+//
+// Relative include, libdir and prefix paths are relative to the directory
+// containing the configuration file. An empty std selects the default
+// dialect.
+type config struct {
+	B       []string // prefix
+	D       []string // define
+	I       []string // include
+	L       []string // libdir
+	extra   []string // extra
+	l       []string // lib
+	noExtra []string // noextra
+	path    string
+	std     string // std
+}
+
+func newConfig(r io.Reader, dir string) (*config, error) {
+	c := &config{}
+	s := bufio.NewScanner(r)
+	seen := map[string]bool{}
+	ln := 0
+	for s.Scan() {
+		ln++
+		l := stripConfigComment(s.Text())
+		if l == "" {
+			continue
+		}
+
+		a := strings.SplitN(l, "=", 2)
+		if len(a) != 2 {
+			return nil, fmt.Errorf("%v: invalid config line: %s", ln, l)
+		}
+
+		nm := strings.TrimSpace(a[0])
+		val := strings.TrimSpace(a[1])
+		if seen[nm] {
+			return nil, fmt.Errorf("%v: duplicate config item: %s", ln, nm)
+		}
+
+		seen[nm] = true
+		for strings.HasPrefix(val, "[") && !strings.HasSuffix(val, "]") && s.Scan() {
+			ln++
+			val += " " + stripConfigComment(s.Text())
+		}
+		v, err := parseConfigValue(val)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s: %v", ln, nm, err)
+		}
+
+		switch nm {
+		case "define":
+			for _, w := range v {
+				c.D = append(c.D, define(w))
+			}
+		case "extra":
+			for _, w := range v {
+				if _, err := extra(w); err != nil {
+					return nil, fmt.Errorf("%v: %v", ln, err)
+				}
+			}
+			c.extra = v
+		case "include":
+			c.I = configPaths(dir, v)
+		case "lib":
+			c.l = v
+		case "libdir":
+			c.L = configPaths(dir, v)
+		case "noextra":
+			for _, w := range v {
+				if _, err := extra(w); err != nil {
+					return nil, fmt.Errorf("%v: %v", ln, err)
+				}
+			}
+			c.noExtra = v
+		case "prefix":
+			c.B = configPaths(dir, v)
+		case "std":
+			if len(v) != 1 {
+				return nil, fmt.Errorf("%v: std: expected string", ln)
+			}
+
+			if v[0] == "" {
+				break
+			}
+
+			if err := checkStd(v[0]); err != nil {
+				return nil, fmt.Errorf("%v: %v", ln, err)
+			}
+
+			c.std = v[0]
+		default:
+			return nil, fmt.Errorf("%v: unknown config item: %s", ln, nm)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func newConfigFile(fn string) (*config, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	c, err := newConfig(f, filepath.Dir(fn))
+	if err != nil {
+		return nil, fmt.Errorf("%s:%v", fn, err)
+	}
+
+	c.path = fn
+	return c, nil
+}
+
+// findConfig returns the name of the configuration file to use, if any.
+// $NINETYNINEC_CONFIG takes precedence over searching for .99c.toml in dir
+// and its parent directories.
+func findConfig(dir string) (string, error) {
+	if fn := os.Getenv("NINETYNINEC_CONFIG"); fn != "" {
+		return fn, nil
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		fn := filepath.Join(dir, configName)
+		if _, err := os.Stat(fn); err == nil {
+			return fn, nil
+		}
+
+		p := filepath.Dir(dir)
+		if p == dir {
+			return "", nil
+		}
+
+		dir = p
+	}
+}
+
+// stripConfigComment returns s without any comment and surrounding white
+// space.
+func stripConfigComment(s string) string {
+	q := byte(0)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case q == '"' && c == '\\':
+			i++
+		case q != 0:
+			if c == q {
+				q = 0
+			}
+		case c == '"' || c == '\'':
+			q = c
+		case c == '#':
+			return strings.TrimSpace(s[:i])
+		}
+	}
+	return strings.TrimSpace(s)
+}
+
+func parseConfigValue(s string) ([]string, error) {
+	if !strings.HasPrefix(s, "[") {
+		v, rest, err := parseConfigString(s)
+		if err != nil {
+			return nil, err
+		}
+
+		if rest = strings.TrimSpace(rest); rest != "" {
+			return nil, fmt.Errorf("unexpected %q", rest)
+		}
+
+		return []string{v}, nil
+	}
+
+	var r []string
+	s = strings.TrimSpace(s[1:])
+	for {
+		if strings.HasPrefix(s, "]") {
+			if rest := strings.TrimSpace(s[1:]); rest != "" {
+				return nil, fmt.Errorf("unexpected %q", rest)
+			}
+
+			return r, nil
+		}
+
+		v, rest, err := parseConfigString(s)
+		if err != nil {
+			return nil, err
+		}
+
+		r = append(r, v)
+		s = strings.TrimSpace(rest)
+		switch {
+		case strings.HasPrefix(s, ","):
+			s = strings.TrimSpace(s[1:])
+		case !strings.HasPrefix(s, "]"):
+			return nil, fmt.Errorf("invalid array: missing comma or ]")
+		}
+	}
+}
+
+func parseConfigString(s string) (v, rest string, err error) {
+	switch {
+	case strings.HasPrefix(s, "'"):
+		i := strings.IndexByte(s[1:], '\'')
+		if i < 0 {
+			return "", "", fmt.Errorf("unterminated string: %s", s)
+		}
+
+		return s[1 : i+1], s[i+2:], nil
+	case strings.HasPrefix(s, `"`):
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				if v, err = strconv.Unquote(s[:i+1]); err != nil {
+					return "", "", fmt.Errorf("invalid string: %s", s[:i+1])
+				}
+
+				return v, s[i+1:], nil
+			}
+		}
+		return "", "", fmt.Errorf("unterminated string: %s", s)
+	}
+	return "", "", fmt.Errorf("expected string: %s", s)
+}
+
+func configPaths(dir string, a []string) []string {
+	r := make([]string, len(a))
+	for i, v := range a {
+		if !filepath.IsAbs(v) {
+			v = filepath.Join(dir, v)
+		}
+		r[i] = v
+	}
+	return r
+}
+
+// merge adds the configuration defaults to a. Command line flags take
+// precedence.
+func (c *config) merge(a *args) {
+	m := map[string]bool{}
+	for _, v := range a.D {
+		m[defineName(v)] = true
+	}
+	var d []string
+	for _, v := range c.D {
+		if !m[defineName(v)] {
+			d = append(d, v)
+		}
+	}
+	a.D = append(d, a.D...)
+	a.I = join(a.I, c.I)
+	a.L = join(a.L, c.L)
+	a.extra = join(c.extra, a.extra)
+	a.l = join(a.l, c.l)
+	a.noExtra = join(a.noExtra, c.noExtra)
+	a.B = join(a.B, c.B)
+	if a.std == "" {
+		a.std = c.std
+	}
+}
+
+// writeConfig writes the effective configuration of a to w. Every key
+// accepted by newConfig is written, so the output can be used as a
+// configuration file. The prefix key lists the effective 99c package
+// prefixes.
+func writeConfig(w io.Writer, a *args, prefixes []string, path string) {
+	if path != "" {
+		fmt.Fprintf(w, "# %s\n", path)
+	}
+	var d []string
+	for _, v := range a.D {
+		v = strings.TrimPrefix(v, "#define ")
+		if i := strings.IndexByte(v, ' '); i >= 0 {
+			v = v[:i] + "=" + v[i+1:]
+		}
+		d = append(d, v)
+	}
+	for _, v := range []struct {
+		nm string
+		a  []string
+	}{
+		{"extra", a.extra},
+		{"define", d},
+		{"include", a.I},
+		{"libdir", a.L},
+		{"lib", a.l},
+		{"noextra", a.noExtra},
+		{"prefix", prefixes},
+	} {
+		var q []string
+		for _, s := range v.a {
+			q = append(q, strconv.Quote(s))
+		}
+		fmt.Fprintf(w, "%s = [%s]\n", v.nm, strings.Join(q, ", "))
+	}
+	fmt.Fprintf(w, "std = %q\n", a.std)
+}
@@ -0,0 +1,95 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func caller(s string, va ...interface{}) {
+	if s == "" {
+		s = strings.Repeat("%v ", len(va))
+	}
+	_, fn, fl, _ := runtime.Caller(2)
+	fmt.Fprintf(os.Stderr, "# caller: %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintf(os.Stderr, s, va...)
+	fmt.Fprintln(os.Stderr)
+	_, fn, fl, _ = runtime.Caller(1)
+	fmt.Fprintf(os.Stderr, "# \tcallee: %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintln(os.Stderr)
+	os.Stderr.Sync()
+}
+
+func dbg(s string, va ...interface{}) {
+	if s == "" {
+		s = strings.Repeat("%v ", len(va))
+	}
+	_, fn, fl, _ := runtime.Caller(1)
+	fmt.Fprintf(os.Stderr, "# dbg %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintf(os.Stderr, s, va...)
+	fmt.Fprintln(os.Stderr)
+	os.Stderr.Sync()
+}
+
+func TODO(...interface{}) string { //TODOOK
+	_, fn, fl, _ := runtime.Caller(1)
+	return fmt.Sprintf("# TODO: %s:%d:\n", path.Base(fn), fl) //TODOOK
+}
+
+func use(...interface{}) {}
+
+func init() {
+	use(caller, dbg, TODO) //TODOOK
+}
+
+// ============================================================================
+
+func TestCore(t *testing.T) {
+	c := &Core{
+		BuildID:  "42",
+		Files:    []File{{FD: 3, Name: "data.txt", Offset: 13}},
+		Message:  "SIGSEGV",
+		Segments: []Segment{{Addr: 0x1000, Data: []byte("hello world"), Name: "data"}},
+		Target:   "linux/amd64",
+		Threads:  []Thread{{PC: 0x12, SP: 0x2000, Stack: []uint64{0x34, 0x56}}},
+	}
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	c2, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(c, c2) {
+		t.Fatalf("got %+v, expected %+v", c2, c)
+	}
+
+	b, err := c2.ReadMemory(0x1006, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := string(b), "world"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	if _, err := c2.ReadMemory(0x1006, 6); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	if _, err := Read(strings.NewReader("\x7f99c")); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
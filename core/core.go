@@ -0,0 +1,128 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package core handles core files, ie. the state of a crashed program executed
+// by the virtual machine.
+//
+// A core file starts with Magic followed by a version byte and the gob
+// encoded Core.
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+)
+
+const (
+	// Magic starts a core file.
+	Magic = "\x7f99core"
+
+	// Version is the core file format version written by this package.
+	Version = 1
+)
+
+// Core is the state of a crashed program.
+type Core struct {
+	// BuildID is the build ID of the executable. See exe.BuildID.
+	BuildID string
+
+	// Executable is the absolute path of the executable.
+	Executable string
+
+	// Files are the files open at the time of the crash.
+	Files []File
+
+	// Message describes the cause of the crash.
+	Message string
+
+	// Segments are the memory segments of the program.
+	Segments []Segment
+
+	// Target is the GOOS/GOARCH of the executable.
+	Target string
+
+	// Threads are the threads of the program, the crashed one first.
+	Threads []Thread
+}
+
+// File is an open file.
+type File struct {
+	FD     int
+	Flags  int
+	Name   string
+	Offset int64
+}
+
+// Segment is a memory segment.
+type Segment struct {
+	Addr uint64
+	Data []byte
+	Name string // "text", "data", "bss", "heap" or "stack N".
+}
+
+// Thread is the state of a thread.
+type Thread struct {
+	ID int
+
+	// Registers of the virtual machine.
+	AP uint64 // Arguments pointer.
+	BP uint64 // Frame pointer.
+	PC uint64 // Program counter.
+	SP uint64 // Stack pointer.
+
+	// Stack holds the return addresses of the active function calls,
+	// the innermost first.
+	Stack []uint64
+}
+
+// WriteTo writes c to w.
+func (c *Core) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	buf.WriteString(Magic)
+	buf.WriteByte(Version)
+	if err := gob.NewEncoder(&buf).Encode(c); err != nil {
+		return 0, err
+	}
+
+	return buf.WriteTo(w)
+}
+
+// Read reads a core file from r.
+func Read(r io.Reader) (*Core, error) {
+	br := bufio.NewReader(r)
+	b, err := br.Peek(len(Magic))
+	if err != nil || string(b) != Magic {
+		return nil, fmt.Errorf("not a core file")
+	}
+
+	br.Discard(len(Magic))
+	v, err := br.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	if v > Version {
+		return nil, fmt.Errorf("unsupported core file version %v", v)
+	}
+
+	var c Core
+	if err := gob.NewDecoder(br).Decode(&c); err != nil {
+		return nil, fmt.Errorf("invalid core file: %v", err)
+	}
+
+	return &c, nil
+}
+
+// ReadMemory returns n bytes of the memory of the program at addr.
+func (c *Core) ReadMemory(addr uint64, n int) ([]byte, error) {
+	for _, v := range c.Segments {
+		if addr >= v.Addr && addr+uint64(n) <= v.Addr+uint64(len(v.Data)) {
+			return v.Data[addr-v.Addr : addr-v.Addr+uint64(n)], nil
+		}
+	}
+	return nil, fmt.Errorf("address %#x+%v not in a core segment", addr, n)
+}
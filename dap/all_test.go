@@ -0,0 +1,333 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cznic/99c/debugger"
+	"github.com/cznic/99c/debuginfo"
+	"github.com/cznic/ir"
+	"github.com/cznic/virtual"
+	"github.com/cznic/xc"
+)
+
+func caller(s string, va ...interface{}) {
+	if s == "" {
+		s = strings.Repeat("%v ", len(va))
+	}
+	_, fn, fl, _ := runtime.Caller(2)
+	fmt.Fprintf(os.Stderr, "# caller: %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintf(os.Stderr, s, va...)
+	fmt.Fprintln(os.Stderr)
+	_, fn, fl, _ = runtime.Caller(1)
+	fmt.Fprintf(os.Stderr, "# \tcallee: %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintln(os.Stderr)
+	os.Stderr.Sync()
+}
+
+func dbg(s string, va ...interface{}) {
+	if s == "" {
+		s = strings.Repeat("%v ", len(va))
+	}
+	_, fn, fl, _ := runtime.Caller(1)
+	fmt.Fprintf(os.Stderr, "# dbg %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintf(os.Stderr, s, va...)
+	fmt.Fprintln(os.Stderr)
+	os.Stderr.Sync()
+}
+
+func TODO(...interface{}) string { //TODOOK
+	_, fn, fl, _ := runtime.Caller(1)
+	return fmt.Sprintf("# TODO: %s:%d:\n", path.Base(fn), fl) //TODOOK
+}
+
+func use(...interface{}) {}
+
+func init() {
+	use(caller, dbg, TODO) //TODOOK
+}
+
+// ============================================================================
+
+// testMachine executes a program of testOps.
+type testMachine struct {
+	frames []debugger.Frame
+	mem    []byte
+	ops    map[uint64]testOp
+}
+
+type testOp struct {
+	call uint64 // Call target.
+	exit bool
+	ret  bool
+}
+
+func (m *testMachine) Depth() int { return len(m.frames) }
+
+func (m *testMachine) Frames() []debugger.Frame { return append([]debugger.Frame(nil), m.frames...) }
+
+func (m *testMachine) PC() uint64 { return m.frames[0].PC }
+
+func (m *testMachine) ReadMemory(addr uint64, n int) ([]byte, error) {
+	if addr < 0x1000 || addr+uint64(n) > 0x1000+uint64(len(m.mem)) {
+		return nil, fmt.Errorf("invalid address %#x", addr)
+	}
+
+	return m.mem[addr-0x1000 : addr-0x1000+uint64(n)], nil
+}
+
+func (m *testMachine) SP() uint64 { return 0x1010 }
+
+func (m *testMachine) Step() (bool, int, error) {
+	op := m.ops[m.frames[0].PC]
+	switch {
+	case op.exit:
+		return true, 42, nil
+	case op.call != 0:
+		m.frames[0].PC++
+		ap := 0x1000 + 0x40*uint64(len(m.frames))
+		m.frames = append([]debugger.Frame{{AP: ap, BP: ap + 0x20, PC: op.call}}, m.frames...)
+	case op.ret:
+		m.frames = m.frames[1:]
+	default:
+		m.frames[0].PC++
+	}
+	return false, 0, nil
+}
+
+func testPCInfo(pc int, name string, line int) virtual.PCInfo {
+	return virtual.PCInfo{PC: pc, Line: line, Column: 1, Name: ir.NameID(xc.Dict.SID(name))}
+}
+
+// newTestTarget returns the program
+//
+//	_start:	0x00 nop		crt0.c:1
+//		0x01 call main
+//		0x02 exit
+//	main:	0x10 nop		a.c:3
+//		0x11 call f		a.c:4
+//		0x12 nop		a.c:5
+//		0x13 ret
+//	f:	0x20 nop		a.c:10
+//		0x21 ret		a.c:11
+//
+// The frame of main is at ap 0x1040, bp 0x1060, the frame of f at ap 0x1080,
+// bp 0x10a0.
+func newTestTarget() *Target {
+	m := &testMachine{
+		frames: []debugger.Frame{{}},
+		mem:    make([]byte, 256),
+		ops: map[uint64]testOp{
+			0x01: {call: 0x10},
+			0x02: {exit: true},
+			0x11: {call: 0x20},
+			0x13: {ret: true},
+			0x21: {ret: true},
+		},
+	}
+	m.mem[0] = 42
+	copy(m.mem[0x4c:], "\x05\x00\x00\x00\x06\x00\x00\x00\x07\x00\x00\x00")
+	copy(m.mem[0x58:], "\x01\x00\x00\x00\x02\x00\x00\x00")
+	m.mem[0x80] = 7
+	return &Target{
+		Binary: &virtual.Binary{
+			Functions: []virtual.PCInfo{
+				testPCInfo(0x00, "_start", 0),
+				testPCInfo(0x10, "main", 0),
+				testPCInfo(0x20, "f", 0),
+			},
+			Lines: []virtual.PCInfo{
+				testPCInfo(0x00, "crt0.c", 1),
+				testPCInfo(0x10, "a.c", 3),
+				testPCInfo(0x11, "a.c", 4),
+				testPCInfo(0x12, "a.c", 5),
+				testPCInfo(0x20, "a.c", 10),
+				testPCInfo(0x21, "a.c", 11),
+			},
+		},
+		Info: &debuginfo.Info{
+			Types: []debuginfo.Type{
+				{Kind: debuginfo.Int, Name: "int", Size: 4, Align: 4},
+				{Kind: debuginfo.Struct, Name: "struct point", Size: 8, Align: 4, Fields: []debuginfo.Field{{Name: "x"}, {Name: "y", Offset: 4}}},
+				{Kind: debuginfo.Array, Size: 12, Align: 4, Len: 3},
+			},
+			Globals: []debuginfo.Variable{{Name: "counter", Offset: 0x1000}},
+			Functions: []debuginfo.Function{
+				{Name: "main", PC: 0x10, End: 0x14, Locals: []debuginfo.Variable{
+					{Name: "p", Base: debuginfo.BP, Offset: -8, PC: 0x11, End: 0x13, Type: 1},
+					{Name: "a", Base: debuginfo.BP, Offset: -20, PC: 0x11, End: 0x13, Type: 2},
+				}},
+				{Name: "f", PC: 0x20, End: 0x22, Params: []debuginfo.Variable{{Name: "n", Base: debuginfo.AP}}},
+			},
+		},
+		Machine: m,
+	}
+}
+
+// testClient is a scripted editor.
+type testClient struct {
+	r   *bufio.Reader
+	seq int
+	t   *testing.T
+	w   io.Writer
+}
+
+func newTestClient(t *testing.T, s *Server) (*testClient, chan error) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	ch := make(chan error, 1)
+	go func() {
+		err := s.Serve(sr, sw)
+		sw.Close()
+		ch <- err
+	}()
+	return &testClient{r: bufio.NewReader(cr), t: t, w: cw}, ch
+}
+
+func (c *testClient) send(command, args string) {
+	c.seq++
+	b := fmt.Sprintf(`{"seq":%d,"type":"request","command":%q,"arguments":%s}`, c.seq, command, args)
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(b), b); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// expect reads a message and compares it, without its seq, to e.
+func (c *testClient) expect(e string) {
+	h, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	n, err := strconv.Atoi(h.Get("Content-Length"))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(c.r, b); err != nil {
+		c.t.Fatal(err)
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		c.t.Fatal(err)
+	}
+
+	delete(m, "seq")
+	if b, err = json.Marshal(m); err != nil {
+		c.t.Fatal(err)
+	}
+
+	if g := string(b); g != e {
+		_, _, line, _ := runtime.Caller(1)
+		c.t.Fatalf("%v:\n---- got\n%s\n---- exp\n%s", line, g, e)
+	}
+}
+
+func TestServe(t *testing.T) {
+	c, ch := newTestClient(t, &Server{
+		Attach: func(core, program string) (*Target, error) {
+			return newTestTarget(), nil
+		},
+	})
+	c.send("initialize", `{"adapterID":"99dbg"}`)
+	c.expect(`{"body":{"supportsConfigurationDoneRequest":true,"supportsFunctionBreakpoints":true,"supportsTerminateRequest":true},"command":"initialize","request_seq":1,"success":true,"type":"response"}`)
+	c.send("attach", `{"core":"a.core"}`)
+	c.expect(`{"command":"attach","request_seq":2,"success":true,"type":"response"}`)
+	c.expect(`{"event":"initialized","type":"event"}`)
+
+	c.send("setBreakpoints", `{"source":{"path":"/home/user/prj/a.c"},"breakpoints":[{"line":10},{"line":99}]}`)
+	c.expect(`{"body":{"breakpoints":[{"id":1,"line":10,"verified":true},{"message":"no code at /home/user/prj/a.c:99","verified":false}]},"command":"setBreakpoints","request_seq":3,"success":true,"type":"response"}`)
+	c.send("setFunctionBreakpoints", `{"breakpoints":[{"name":"nosuch"}]}`)
+	c.expect(`{"body":{"breakpoints":[{"message":"function nosuch not found","verified":false}]},"command":"setFunctionBreakpoints","request_seq":4,"success":true,"type":"response"}`)
+	c.send("configurationDone", `{}`)
+	c.expect(`{"command":"configurationDone","request_seq":5,"success":true,"type":"response"}`)
+	c.expect(`{"body":{"allThreadsStopped":true,"hitBreakpointIds":[1],"reason":"breakpoint","threadId":1},"event":"stopped","type":"event"}`)
+	c.send("threads", `{}`)
+	c.expect(`{"body":{"threads":[{"id":1,"name":"main"}]},"command":"threads","request_seq":6,"success":true,"type":"response"}`)
+	c.send("stackTrace", `{"threadId":1}`)
+	c.expect(`{"body":{"stackFrames":[{"column":1,"id":0,"line":10,"name":"f","source":{"name":"a.c","path":"a.c"}},{"column":1,"id":1,"line":5,"name":"main","source":{"name":"a.c","path":"a.c"}},{"column":1,"id":2,"line":1,"name":"_start","source":{"name":"crt0.c","path":"crt0.c"}}],"totalFrames":3},"command":"stackTrace","request_seq":7,"success":true,"type":"response"}`)
+	c.send("scopes", `{"frameId":0}`)
+	c.expect(`{"body":{"scopes":[{"expensive":false,"name":"Locals","variablesReference":1},{"expensive":false,"name":"Globals","variablesReference":2}]},"command":"scopes","request_seq":8,"success":true,"type":"response"}`)
+	c.send("variables", `{"variablesReference":1}`)
+	c.expect(`{"body":{"variables":[{"name":"n","type":"int","value":"7","variablesReference":0}]},"command":"variables","request_seq":9,"success":true,"type":"response"}`)
+	c.send("variables", `{"variablesReference":2}`)
+	c.expect(`{"body":{"variables":[{"name":"counter","type":"int","value":"42","variablesReference":0}]},"command":"variables","request_seq":10,"success":true,"type":"response"}`)
+	c.send("scopes", `{"frameId":1}`)
+	c.expect(`{"body":{"scopes":[{"expensive":false,"name":"Locals","variablesReference":3},{"expensive":false,"name":"Globals","variablesReference":4}]},"command":"scopes","request_seq":11,"success":true,"type":"response"}`)
+	c.send("variables", `{"variablesReference":3}`)
+	c.expect(`{"body":{"variables":[{"name":"p","type":"struct point","value":"{x = 1, y = 2}","variablesReference":5},{"indexedVariables":3,"name":"a","type":"int [3]","value":"{5, 6, 7}","variablesReference":6}]},"command":"variables","request_seq":12,"success":true,"type":"response"}`)
+	c.send("variables", `{"variablesReference":5}`)
+	c.expect(`{"body":{"variables":[{"name":"x","type":"int","value":"1","variablesReference":0},{"name":"y","type":"int","value":"2","variablesReference":0}]},"command":"variables","request_seq":13,"success":true,"type":"response"}`)
+	c.send("variables", `{"variablesReference":6,"start":1,"count":1}`)
+	c.expect(`{"body":{"variables":[{"name":"[1]","type":"int","value":"6","variablesReference":0}]},"command":"variables","request_seq":14,"success":true,"type":"response"}`)
+	c.send("variables", `{"variablesReference":6,"start":1}`)
+	c.expect(`{"body":{"variables":[{"name":"[1]","type":"int","value":"6","variablesReference":0},{"name":"[2]","type":"int","value":"7","variablesReference":0}]},"command":"variables","request_seq":15,"success":true,"type":"response"}`)
+	c.send("variables", `{"variablesReference":6,"filter":"named"}`)
+	c.expect(`{"body":{"variables":[]},"command":"variables","request_seq":16,"success":true,"type":"response"}`)
+	c.send("stepOut", `{"threadId":1}`)
+	c.expect(`{"command":"stepOut","request_seq":17,"success":true,"type":"response"}`)
+	c.expect(`{"body":{"allThreadsStopped":true,"reason":"step","threadId":1},"event":"stopped","type":"event"}`)
+	c.send("variables", `{"variablesReference":5}`)
+	c.expect(`{"command":"variables","message":"invalid variablesReference 5","request_seq":18,"success":false,"type":"response"}`)
+	c.send("next", `{"threadId":1}`)
+	c.expect(`{"command":"next","request_seq":19,"success":true,"type":"response"}`)
+	c.expect(`{"body":{"allThreadsStopped":true,"reason":"step","threadId":1},"event":"stopped","type":"event"}`)
+	c.send("continue", `{"threadId":1}`)
+	c.expect(`{"body":{"allThreadsContinued":true},"command":"continue","request_seq":20,"success":true,"type":"response"}`)
+	c.expect(`{"body":{"exitCode":42},"event":"exited","type":"event"}`)
+	c.expect(`{"event":"terminated","type":"event"}`)
+	c.send("stepIn", `{"threadId":1}`)
+	c.expect(`{"command":"stepIn","message":"stepIn: the program exited","request_seq":21,"success":false,"type":"response"}`)
+	c.send("disconnect", `{}`)
+	c.expect(`{"command":"disconnect","request_seq":22,"success":true,"type":"response"}`)
+	if err := <-ch; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAttach(t *testing.T) {
+	c, ch := newTestClient(t, &Server{
+		Attach: func(core, program string) (*Target, error) {
+			if core != "a.core" || program != "" {
+				return nil, fmt.Errorf("unexpected arguments %q %q", core, program)
+			}
+
+			t := newTestTarget()
+			t.Message = "SIGSEGV"
+			return t, nil
+		},
+	})
+	c.send("launch", `{"program":"a.out"}`)
+	c.expect(`{"command":"launch","message":"launch: not supported","request_seq":1,"success":false,"type":"response"}`)
+	c.send("threads", `{}`)
+	c.expect(`{"command":"threads","message":"threads: no program, send attach first","request_seq":2,"success":false,"type":"response"}`)
+	c.send("attach", `{"core":"a.core"}`)
+	c.expect(`{"command":"attach","request_seq":3,"success":true,"type":"response"}`)
+	c.expect(`{"event":"initialized","type":"event"}`)
+	c.send("configurationDone", `{}`)
+	c.expect(`{"command":"configurationDone","request_seq":4,"success":true,"type":"response"}`)
+	c.expect(`{"body":{"allThreadsStopped":true,"reason":"exception","text":"SIGSEGV","threadId":1},"event":"stopped","type":"event"}`)
+	c.send("continue", `{"threadId":1}`)
+	c.expect(`{"command":"continue","message":"continue: the program crashed: SIGSEGV","request_seq":5,"success":false,"type":"response"}`)
+	c.send("terminate", `{}`)
+	c.expect(`{"command":"terminate","request_seq":6,"success":true,"type":"response"}`)
+	c.expect(`{"event":"terminated","type":"event"}`)
+	if err := <-ch; err != nil {
+		t.Fatal(err)
+	}
+}
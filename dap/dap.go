@@ -0,0 +1,585 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package dap implements a Debug Adapter Protocol server debugging programs
+// executed by the virtual machine.
+//
+// The server lets editors like VS Code set breakpoints, step through the
+// program and inspect its call stack and variables. See
+// https://microsoft.github.io/debug-adapter-protocol/ for the protocol.
+//
+// Supported requests
+//
+//	initialize, attach, configurationDone, setBreakpoints,
+//	setFunctionBreakpoints, setExceptionBreakpoints, threads, stackTrace,
+//	scopes, variables, continue, next, stepIn, stepOut, terminate and
+//	disconnect.
+//
+// The program is executed synchronously while serving a request, so it cannot
+// be paused. It has a single thread with ID 1. Launch requests are not
+// supported, the virtual machine provides no way to execute a program under
+// the control of a debugger.
+//
+// Attach arguments
+//
+//	core		the core file of a crashed program, required
+//	program		the executable, defaults to the one recorded in the core
+//			file
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/cznic/99c/debugger"
+	"github.com/cznic/99c/debuginfo"
+	"github.com/cznic/virtual"
+)
+
+// Target is a program being debugged.
+type Target struct {
+	Binary  *virtual.Binary
+	Info    *debuginfo.Info // Variable information, if any.
+	Machine debugger.Machine
+	Message string // Why the program crashed, if it did.
+}
+
+// Server serves the Debug Adapter Protocol.
+type Server struct {
+	// Attach loads the core file of a crashed program and the executable
+	// program. An empty program means the one recorded in the core file.
+	Attach func(core, program string) (*Target, error)
+}
+
+// header is common to all messages.
+type header struct {
+	Seq  int    `json:"seq"`
+	Type string `json:"type"`
+}
+
+type request struct {
+	header
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+	Command   string          `json:"command"`
+}
+
+type response struct {
+	header
+	Body       interface{} `json:"body,omitempty"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+}
+
+type event struct {
+	header
+	Body  interface{} `json:"body,omitempty"`
+	Event string      `json:"event"`
+}
+
+type source struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type breakpoint struct {
+	ID       int    `json:"id,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Message  string `json:"message,omitempty"`
+	Verified bool   `json:"verified"`
+}
+
+type stackFrame struct {
+	Column int     `json:"column"`
+	ID     int     `json:"id"`
+	Line   int     `json:"line"`
+	Name   string  `json:"name"`
+	Source *source `json:"source,omitempty"`
+}
+
+type scope struct {
+	Expensive          bool   `json:"expensive"`
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+type variable struct {
+	IndexedVariables   int    `json:"indexedVariables,omitempty"` // Arrays only.
+	Name               string `json:"name"`
+	Type               string `json:"type,omitempty"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+// reference is what a variablesReference refers to, the variables of a scope
+// or the members of a value.
+type reference struct {
+	addr  uint64
+	frame debugger.Frame
+	vars  []debuginfo.Variable // Scopes only.
+	typ   int                  // Values only.
+	value bool
+}
+
+// session is a connection of a client.
+type session struct {
+	*Server
+	d         *debugger.Debugger
+	exited    bool
+	mu        sync.Mutex // Protects seq and w.
+	r         *bufio.Reader
+	refs      []reference // variablesReference - 1.
+	seq       int
+	sources   map[string][]int // Breakpoints by source path.
+	functions []int            // Function breakpoints.
+	t         *Target
+	w         io.Writer
+}
+
+// Serve serves a client reading requests from r and writing responses and
+// events to w until the client disconnects or terminates the program.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	ss := &session{Server: s, r: bufio.NewReader(r), sources: map[string][]int{}, w: w}
+	for {
+		m, err := ss.read()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			return err
+		}
+
+		if m.Type != "request" {
+			continue
+		}
+
+		body, err := ss.handle(m)
+		if err != nil {
+			ss.respond(m, nil, err)
+			continue
+		}
+
+		ss.respond(m, body, nil)
+		switch m.Command {
+		case "attach":
+			ss.event("initialized", nil)
+		case "configurationDone":
+			ss.start()
+		case "continue":
+			ss.stopped(ss.d.Continue())
+		case "next":
+			ss.stopped(ss.d.Next())
+		case "stepIn":
+			ss.stopped(ss.d.Step())
+		case "stepOut":
+			ss.stopped(ss.d.Finish())
+		case "terminate":
+			ss.event("terminated", nil)
+			return nil
+		case "disconnect":
+			return nil
+		}
+	}
+}
+
+// read reads a message framed by a Content-Length header.
+func (s *session) read() (*request, error) {
+	h, err := textproto.NewReader(s.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.Atoi(h.Get("Content-Length"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid Content-Length: %q", h.Get("Content-Length"))
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(s.r, b); err != nil {
+		return nil, err
+	}
+
+	var m request
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// write writes m having the header h.
+func (s *session) write(h *header, m interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	h.Seq = s.seq
+	b, err := json.Marshal(m)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n%s", len(b), b)
+}
+
+func (s *session) respond(req *request, body interface{}, err error) {
+	m := &response{header: header{Type: "response"}, Body: body, Command: req.Command, RequestSeq: req.Seq, Success: err == nil}
+	if err != nil {
+		m.Message = err.Error()
+	}
+	s.write(&m.header, m)
+}
+
+func (s *session) event(name string, body interface{}) {
+	m := &event{header: header{Type: "event"}, Body: body, Event: name}
+	s.write(&m.header, m)
+}
+
+// handle serves the request m and returns the body of the response. Running
+// the program is left to Serve, after the response was sent.
+func (s *session) handle(m *request) (interface{}, error) {
+	switch m.Command {
+	case "initialize":
+		return map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsFunctionBreakpoints":      true,
+			"supportsTerminateRequest":         true,
+		}, nil
+	case "attach":
+		return nil, s.attach(m.Arguments)
+	case "launch":
+		return nil, fmt.Errorf("launch: not supported")
+	case "disconnect":
+		return nil, nil
+	}
+
+	if s.t == nil {
+		return nil, fmt.Errorf("%s: no program, send attach first", m.Command)
+	}
+
+	switch m.Command {
+	case "configurationDone", "setExceptionBreakpoints", "terminate":
+		return nil, nil
+	case "setBreakpoints":
+		return s.setBreakpoints(m.Arguments)
+	case "setFunctionBreakpoints":
+		return s.setFunctionBreakpoints(m.Arguments)
+	case "threads":
+		return map[string]interface{}{"threads": []interface{}{map[string]interface{}{"id": 1, "name": "main"}}}, nil
+	case "stackTrace":
+		return s.stackTrace()
+	case "scopes":
+		return s.scopes(m.Arguments)
+	case "variables":
+		return s.variables(m.Arguments)
+	case "continue", "next", "stepIn", "stepOut":
+		switch {
+		case s.t.Message != "":
+			return nil, fmt.Errorf("%s: the program crashed: %s", m.Command, s.t.Message)
+		case s.exited:
+			return nil, fmt.Errorf("%s: the program exited", m.Command)
+		case m.Command == "continue":
+			return map[string]interface{}{"allThreadsContinued": true}, nil
+		}
+
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported request: %s", m.Command)
+}
+
+func (s *session) attach(b json.RawMessage) error {
+	var args struct {
+		Core    string `json:"core"`
+		Program string `json:"program"`
+	}
+	if err := json.Unmarshal(b, &args); err != nil {
+		return err
+	}
+
+	if s.Attach == nil {
+		return fmt.Errorf("attach: not supported")
+	}
+
+	if args.Core == "" {
+		return fmt.Errorf("attach: missing core")
+	}
+
+	t, err := s.Attach(args.Core, args.Program)
+	if err != nil {
+		return err
+	}
+
+	s.target(t)
+	return nil
+}
+
+func (s *session) target(t *Target) {
+	s.t = t
+	s.d = debugger.New(t.Machine, t.Binary, t.Info, ioutil.Discard)
+}
+
+// start reports a crash or runs the program after the client sent its
+// configuration.
+func (s *session) start() {
+	switch {
+	case s.t.Message != "":
+		s.event("stopped", map[string]interface{}{"allThreadsStopped": true, "reason": "exception", "text": s.t.Message, "threadId": 1})
+	default:
+		s.stopped(s.d.Continue())
+	}
+}
+
+// stopped reports why the program stopped.
+func (s *session) stopped(st debugger.Stop, err error) {
+	s.refs = nil
+	switch {
+	case err != nil:
+		s.event("output", map[string]interface{}{"category": "stderr", "output": err.Error() + "\n"})
+		s.event("stopped", map[string]interface{}{"allThreadsStopped": true, "reason": "exception", "text": err.Error(), "threadId": 1})
+	case st.Exited:
+		s.exited = true
+		s.event("exited", map[string]interface{}{"exitCode": st.Code})
+		s.event("terminated", nil)
+	case st.Breakpoint != 0:
+		s.event("stopped", map[string]interface{}{"allThreadsStopped": true, "hitBreakpointIds": []int{st.Breakpoint}, "reason": "breakpoint", "threadId": 1})
+	default:
+		s.event("stopped", map[string]interface{}{"allThreadsStopped": true, "reason": "step", "threadId": 1})
+	}
+}
+
+// setBreakpoints replaces the breakpoints of a source file.
+func (s *session) setBreakpoints(b json.RawMessage) (interface{}, error) {
+	var args struct {
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+		Source source `json:"source"`
+	}
+	if err := json.Unmarshal(b, &args); err != nil {
+		return nil, err
+	}
+
+	path := args.Source.Path
+	if path == "" {
+		path = args.Source.Name
+	}
+	for _, n := range s.sources[path] {
+		s.d.DeleteBreakpoint(n)
+	}
+	s.sources[path] = nil
+	r := []breakpoint{}
+	for _, v := range args.Breakpoints {
+		bp := s.setBreakpoint(fmt.Sprintf("%s:%d", filepath.ToSlash(path), v.Line))
+		if bp.Verified {
+			s.sources[path] = append(s.sources[path], bp.ID)
+		}
+		r = append(r, bp)
+	}
+	return map[string]interface{}{"breakpoints": r}, nil
+}
+
+// setFunctionBreakpoints replaces the function breakpoints.
+func (s *session) setFunctionBreakpoints(b json.RawMessage) (interface{}, error) {
+	var args struct {
+		Breakpoints []struct {
+			Name string `json:"name"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(b, &args); err != nil {
+		return nil, err
+	}
+
+	for _, n := range s.functions {
+		s.d.DeleteBreakpoint(n)
+	}
+	s.functions = nil
+	r := []breakpoint{}
+	for _, v := range args.Breakpoints {
+		bp := s.setBreakpoint(v.Name)
+		if bp.Verified {
+			s.functions = append(s.functions, bp.ID)
+		}
+		r = append(r, bp)
+	}
+	return map[string]interface{}{"breakpoints": r}, nil
+}
+
+// setBreakpoint sets a breakpoint at the debugger LOCATION loc.
+func (s *session) setBreakpoint(loc string) breakpoint {
+	n, pc, err := s.d.SetBreakpoint(loc)
+	if err != nil {
+		return breakpoint{Message: err.Error()}
+	}
+
+	_, line, _, _ := debugger.Position(s.t.Binary, pc)
+	return breakpoint{ID: n, Line: line, Verified: true}
+}
+
+func (s *session) stackTrace() (interface{}, error) {
+	frames := s.t.Machine.Frames()
+	r := []stackFrame{}
+	for i, f := range frames {
+		sf := stackFrame{ID: i, Name: fmt.Sprintf("%#05x", f.PC)}
+		if fn, _ := debugger.Symbolize(s.t.Binary, f.PC); fn != "" {
+			sf.Name = fn
+		}
+		if file, line, column, ok := debugger.Position(s.t.Binary, f.PC); ok {
+			sf.Source = &source{Name: filepath.Base(file), Path: file}
+			sf.Line, sf.Column = line, column
+		}
+		r = append(r, sf)
+	}
+	return map[string]interface{}{"stackFrames": r, "totalFrames": len(r)}, nil
+}
+
+// frame returns the frame number n.
+func (s *session) frame(n int) (debugger.Frame, error) {
+	f := s.t.Machine.Frames()
+	if n < 0 || n >= len(f) {
+		return debugger.Frame{}, fmt.Errorf("no frame %d", n)
+	}
+
+	return f[n], nil
+}
+
+// reference returns a new variablesReference of r.
+func (s *session) reference(r reference) int {
+	s.refs = append(s.refs, r)
+	return len(s.refs)
+}
+
+func (s *session) scopes(b json.RawMessage) (interface{}, error) {
+	var args struct {
+		FrameID int `json:"frameId"`
+	}
+	if err := json.Unmarshal(b, &args); err != nil {
+		return nil, err
+	}
+
+	f, err := s.frame(args.FrameID)
+	if err != nil {
+		return nil, err
+	}
+
+	r := []scope{}
+	if s.t.Info != nil {
+		if fn := s.t.Info.Function(f.PC); fn != nil {
+			r = append(r, scope{Name: "Locals", VariablesReference: s.reference(reference{frame: f, vars: fn.Scope(f.PC)})})
+		}
+		r = append(r, scope{Name: "Globals", VariablesReference: s.reference(reference{frame: f, vars: s.t.Info.Globals})})
+	}
+	return map[string]interface{}{"scopes": r}, nil
+}
+
+func (s *session) variables(b json.RawMessage) (interface{}, error) {
+	var args struct {
+		Count              int64  `json:"count"`
+		Filter             string `json:"filter"`
+		Start              int64  `json:"start"`
+		VariablesReference int    `json:"variablesReference"`
+	}
+	if err := json.Unmarshal(b, &args); err != nil {
+		return nil, err
+	}
+
+	n := args.VariablesReference
+	if n < 1 || n > len(s.refs) {
+		return nil, fmt.Errorf("invalid variablesReference %d", n)
+	}
+
+	ref := s.refs[n-1]
+	info := s.t.Info
+	r := []variable{}
+	if !ref.value {
+		for i := range ref.vars {
+			v := &ref.vars[i]
+			addr, err := ref.frame.Addr(v)
+			if err != nil {
+				r = append(r, variable{Name: v.Name, Type: info.TypeString(v.Type), Value: err.Error()})
+				continue
+			}
+
+			r = append(r, s.variable(v.Name, v.Type, addr))
+		}
+		return map[string]interface{}{"variables": r}, nil
+	}
+
+	var fields []debuginfo.Field
+	switch t := info.Underlying(ref.typ); t.Kind {
+	case debuginfo.Array:
+		fields = elements(info, ref.typ, t.Len, args.Filter, args.Start, args.Count)
+	default:
+		fields = info.Members(ref.typ)
+	}
+	for _, f := range fields {
+		if f.Bits == 0 {
+			r = append(r, s.variable(f.Name, f.Type, ref.addr+uint64(f.Offset)))
+			continue
+		}
+
+		v := variable{Name: f.Name, Type: info.TypeString(f.Type)}
+		mem, err := s.t.Machine.ReadMemory(ref.addr, int(info.Size(ref.typ)))
+		switch {
+		case err != nil:
+			v.Value = err.Error()
+		default:
+			v.Value = info.FieldValue(f, mem)
+		}
+		r = append(r, v)
+	}
+	return map[string]interface{}{"variables": r}, nil
+}
+
+// elements returns the elements of the array of type t and length n selected
+// by the filter, start and count arguments of a variables request. Only the
+// selected elements are built, arrays can be large.
+func elements(info *debuginfo.Info, t int, n int64, filter string, start, count int64) []debuginfo.Field {
+	if filter == "named" || start < 0 || start >= n {
+		return nil
+	}
+
+	end := n
+	if count > 0 && count < n-start {
+		end = start + count
+	}
+	var r []debuginfo.Field
+	for j := start; j < end; j++ {
+		r = append(r, info.Element(t, j))
+	}
+	return r
+}
+
+// variable describes the value of type t at addr.
+func (s *session) variable(name string, t int, addr uint64) variable {
+	info := s.t.Info
+	v := variable{Name: name, Type: info.TypeString(t)}
+	mem, err := s.t.Machine.ReadMemory(addr, int(info.Size(t)))
+	if err != nil {
+		v.Value = err.Error()
+		return v
+	}
+
+	v.Value = info.Value(t, mem)
+	switch u := info.Underlying(t); u.Kind {
+	case debuginfo.Array:
+		if u.Len != 0 {
+			v.VariablesReference = s.reference(reference{addr: addr, typ: t, value: true})
+			v.IndexedVariables = int(u.Len)
+		}
+	case debuginfo.Struct, debuginfo.Union:
+		if len(u.Fields) != 0 {
+			v.VariablesReference = s.reference(reference{addr: addr, typ: t, value: true})
+		}
+	}
+	return v
+}
@@ -0,0 +1,384 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debugger
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/cznic/99c/core"
+	"github.com/cznic/99c/debuginfo"
+	"github.com/cznic/ir"
+	"github.com/cznic/virtual"
+	"github.com/cznic/xc"
+)
+
+func caller(s string, va ...interface{}) {
+	if s == "" {
+		s = strings.Repeat("%v ", len(va))
+	}
+	_, fn, fl, _ := runtime.Caller(2)
+	fmt.Fprintf(os.Stderr, "# caller: %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintf(os.Stderr, s, va...)
+	fmt.Fprintln(os.Stderr)
+	_, fn, fl, _ = runtime.Caller(1)
+	fmt.Fprintf(os.Stderr, "# \tcallee: %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintln(os.Stderr)
+	os.Stderr.Sync()
+}
+
+func dbg(s string, va ...interface{}) {
+	if s == "" {
+		s = strings.Repeat("%v ", len(va))
+	}
+	_, fn, fl, _ := runtime.Caller(1)
+	fmt.Fprintf(os.Stderr, "# dbg %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintf(os.Stderr, s, va...)
+	fmt.Fprintln(os.Stderr)
+	os.Stderr.Sync()
+}
+
+func TODO(...interface{}) string { //TODOOK
+	_, fn, fl, _ := runtime.Caller(1)
+	return fmt.Sprintf("# TODO: %s:%d:\n", path.Base(fn), fl) //TODOOK
+}
+
+func use(...interface{}) {}
+
+func init() {
+	use(caller, dbg, TODO) //TODOOK
+}
+
+// ============================================================================
+
+// testMachine executes a program of testOps.
+type testMachine struct {
+	frames []Frame
+	mem    []byte
+	ops    map[uint64]testOp
+}
+
+type testOp struct {
+	call uint64 // Call target.
+	exit bool
+	ret  bool
+}
+
+func (m *testMachine) Depth() int { return len(m.frames) }
+
+func (m *testMachine) Frames() []Frame { return append([]Frame(nil), m.frames...) }
+
+func (m *testMachine) PC() uint64 { return m.frames[0].PC }
+
+func (m *testMachine) ReadMemory(addr uint64, n int) ([]byte, error) {
+	if addr < 0x1000 || addr+uint64(n) > 0x1000+uint64(len(m.mem)) {
+		return nil, fmt.Errorf("invalid address %#x", addr)
+	}
+
+	return m.mem[addr-0x1000 : addr-0x1000+uint64(n)], nil
+}
+
+func (m *testMachine) SP() uint64 { return 0x1010 }
+
+func (m *testMachine) Step() (bool, int, error) {
+	op := m.ops[m.frames[0].PC]
+	switch {
+	case op.exit:
+		return true, 42, nil
+	case op.call != 0:
+		m.frames[0].PC++
+		ap := 0x1000 + 0x40*uint64(len(m.frames))
+		m.frames = append([]Frame{{AP: ap, BP: ap + 0x20, PC: op.call}}, m.frames...)
+	case op.ret:
+		m.frames = m.frames[1:]
+	default:
+		m.frames[0].PC++
+	}
+	return false, 0, nil
+}
+
+func testPCInfo(pc int, name string, line int) virtual.PCInfo {
+	return virtual.PCInfo{PC: pc, Line: line, Column: 1, Name: ir.NameID(xc.Dict.SID(name))}
+}
+
+// newTestDebugger returns a debugger of the program
+//
+//	_start:	0x00 nop	crt0.c:1
+//		0x01 call main
+//		0x02 exit
+//	main:	0x10 nop	a.c:3
+//		0x11 call f	a.c:4
+//		0x12 nop	a.c:5
+//		0x13 ret
+//	f:	0x20 nop	a.c:10
+//		0x21 ret	a.c:11
+//
+// The frame of main is at ap 0x1040, bp 0x1060, the frame of f at ap 0x1080,
+// bp 0x10a0.
+func newTestDebugger(w io.Writer, info *debuginfo.Info) *Debugger {
+	m := &testMachine{
+		frames: []Frame{{}},
+		mem:    make([]byte, 256),
+		ops: map[uint64]testOp{
+			0x01: {call: 0x10},
+			0x02: {exit: true},
+			0x11: {call: 0x20},
+			0x13: {ret: true},
+			0x21: {ret: true},
+		},
+	}
+	m.mem[0] = 42
+	m.mem[16] = 0xff
+	copy(m.mem[0x58:], "\x01\x00\x00\x00\x02\x00\x00\x00")
+	m.mem[0x80] = 7
+	return New(m, testBinary(), info, w)
+}
+
+// testBinary returns the binary of the program of newTestDebugger.
+func testBinary() *virtual.Binary {
+	return &virtual.Binary{
+		Functions: []virtual.PCInfo{
+			testPCInfo(0x00, "_start", 0),
+			testPCInfo(0x10, "main", 0),
+			testPCInfo(0x20, "f", 0),
+		},
+		Lines: []virtual.PCInfo{
+			testPCInfo(0x00, "crt0.c", 1),
+			testPCInfo(0x10, "a.c", 3),
+			testPCInfo(0x11, "a.c", 4),
+			testPCInfo(0x12, "a.c", 5),
+			testPCInfo(0x20, "a.c", 10),
+			testPCInfo(0x21, "a.c", 11),
+		},
+		Sym: map[ir.NameID]int{ir.NameID(xc.Dict.SID("counter")): 0x1000},
+	}
+}
+
+func TestDebugger(t *testing.T) {
+	for i, v := range []struct {
+		script, out string
+	}{
+		{
+			`break main
+continue
+next
+
+backtrace
+continue
+backtrace
+`,
+			`Breakpoint 1 at 0x00010 in main (a.c:3:1)
+Breakpoint 1, 0x00010 in main (a.c:3:1)
+0x00011 in main (a.c:4:1)
+0x00012 in main (a.c:5:1)
+#0  0x00012 in main (a.c:5:1)
+#1  0x00002 in _start (crt0.c:1:1)
+Program exited with code 42
+the program is not being run
+`,
+		},
+		{
+			`break main
+break a.c:3
+delete 1
+continue
+`,
+			`Breakpoint 1 at 0x00010 in main (a.c:3:1)
+Breakpoint 2 at 0x00010 in main (a.c:3:1)
+Breakpoint 2, 0x00010 in main (a.c:3:1)
+`,
+		},
+		{
+			`b a.c:10
+info breakpoints
+c
+finish
+step
+stepi
+`,
+			`Breakpoint 1 at 0x00020 in f (a.c:10:1)
+1	a.c:10	0x00020 in f (a.c:10:1)
+Breakpoint 1, 0x00020 in f (a.c:10:1)
+Run till exit from 0x00020 in f (a.c:10:1)
+0x00012 in main (a.c:5:1)
+0x00002 in _start (crt0.c:1:1)
+Program exited with code 42
+`,
+		},
+		{
+			`break *0x11
+c
+nexti
+delete 1
+info b
+si
+si
+info registers
+x/20 counter
+x/8 $sp
+print counter
+stack 1
+print nosuch
+frobnicate
+`,
+			`Breakpoint 1 at 0x00011 in main (a.c:4:1)
+Breakpoint 1, 0x00011 in main (a.c:4:1)
+0x00012 in main (a.c:5:1)
+No breakpoints
+0x00013 in main (a.c:5:1)
+0x00002 in _start (crt0.c:1:1)
+pc	0x2
+ap	0x0
+bp	0x0
+sp	0x1010
+0x00001000: 2a 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00
+0x00001010: ff 00 00 00
+0x00001010: ff 00 00 00 00 00 00 00
+counter at 0x00001000 = 0x000000000000002a
+0x00001010: 0x00000000000000ff
+no symbol "nosuch"
+unknown command "frobnicate", try help
+`,
+		},
+	} {
+		var buf bytes.Buffer
+		if err := newTestDebugger(&buf, nil).Run(strings.NewReader(v.script), ""); err != nil {
+			t.Fatal(i, err)
+		}
+
+		if g, e := buf.String(), v.out; g != e {
+			t.Errorf("%v\n---- got\n%s---- exp\n%s", i, g, e)
+		}
+	}
+}
+
+func TestVariables(t *testing.T) {
+	info := &debuginfo.Info{
+		Types: []debuginfo.Type{
+			{Kind: debuginfo.Int, Name: "int", Size: 4, Align: 4},
+			{Kind: debuginfo.Struct, Name: "struct point", Size: 8, Align: 4, Fields: []debuginfo.Field{{Name: "x"}, {Name: "y", Offset: 4}}},
+		},
+		Globals: []debuginfo.Variable{{Name: "counter", Offset: 0x1000}},
+		Functions: []debuginfo.Function{
+			{Name: "main", PC: 0x10, End: 0x14, Locals: []debuginfo.Variable{{Name: "p", Base: debuginfo.BP, Offset: -8, PC: 0x11, End: 0x13, Type: 1}}},
+			{Name: "f", PC: 0x20, End: 0x22, Params: []debuginfo.Variable{{Name: "n", Base: debuginfo.AP}}},
+		},
+	}
+	var buf bytes.Buffer
+	if err := newTestDebugger(&buf, info).Run(strings.NewReader(`break f
+c
+print n
+print counter
+info locals
+frame 1
+print p
+ptype p
+x/4 p
+print n
+frame 5
+finish
+info locals
+`), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := buf.String(), `Breakpoint 1 at 0x00020 in f (a.c:10:1)
+Breakpoint 1, 0x00020 in f (a.c:10:1)
+n = 7
+counter = 42
+n = 7
+#1  0x00012 in main (a.c:5:1)
+p = {x = 1, y = 2}
+type = struct point {
+    int x;
+    int y;
+}
+0x00001058: 01 00 00 00
+no symbol "n"
+no frame 5
+Run till exit from 0x00020 in f (a.c:10:1)
+0x00012 in main (a.c:5:1)
+p = {x = 1, y = 2}
+`; g != e {
+		t.Errorf("---- got\n%s---- exp\n%s", g, e)
+	}
+}
+
+func TestCoreMachine(t *testing.T) {
+	m := CoreMachine(&core.Core{
+		Message:  "SIGSEGV",
+		Segments: []core.Segment{{Addr: 0x1000, Data: []byte("hello world"), Name: "data"}},
+		Threads:  []core.Thread{{AP: 0x2000, BP: 0x2100, PC: 0x12, SP: 0x2200, Stack: []uint64{0x34, 0x56}}},
+	})
+	if g, e := fmt.Sprint(m.Frames()), "[{8192 8448 18} {0 0 52} {0 0 86}]"; g != e {
+		t.Fatalf("got %s, expected %s", g, e)
+	}
+
+	if g, e := m.PC(), uint64(0x12); g != e {
+		t.Fatalf("got %#x, expected %#x", g, e)
+	}
+
+	if g, e := m.Depth(), 3; g != e {
+		t.Fatalf("got %v, expected %v", g, e)
+	}
+
+	if g, e := m.SP(), uint64(0x2200); g != e {
+		t.Fatalf("got %#x, expected %#x", g, e)
+	}
+
+	b, err := m.ReadMemory(0x1006, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := string(b), "world"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	if _, _, err := m.Step(); err == nil || !strings.Contains(err.Error(), "SIGSEGV") {
+		t.Fatal(err)
+	}
+}
+
+func TestCoreVariables(t *testing.T) {
+	info := &debuginfo.Info{
+		Types:   []debuginfo.Type{{Kind: debuginfo.Int, Name: "int", Size: 4, Align: 4}},
+		Globals: []debuginfo.Variable{{Name: "counter", Offset: 0x1000}},
+		Functions: []debuginfo.Function{
+			{Name: "main", PC: 0x10, End: 0x14, Locals: []debuginfo.Variable{{Name: "i", Base: debuginfo.BP, Offset: -8, PC: 0x11, End: 0x13}}},
+			{Name: "f", PC: 0x20, End: 0x22, Params: []debuginfo.Variable{{Name: "n", Base: debuginfo.AP}}},
+		},
+	}
+	m := CoreMachine(&core.Core{
+		Segments: []core.Segment{{Addr: 0x1000, Data: []byte{42, 0, 0, 0, 0, 0, 0, 0, 7, 0, 0, 0}, Name: "data"}},
+		Threads:  []core.Thread{{AP: 0x1008, BP: 0x1008, PC: 0x21, Stack: []uint64{0x12, 0x02}}},
+	})
+	var buf bytes.Buffer
+	if err := New(m, testBinary(), info, &buf).Run(strings.NewReader(`print n
+frame 1
+print i
+x/4 i
+info locals
+print counter
+`), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := buf.String(), `n = 7
+#1  0x00012 in main (a.c:5:1)
+the core file records the registers of the innermost frame only
+the core file records the registers of the innermost frame only
+the core file records the registers of the innermost frame only
+counter = 42
+`; g != e {
+		t.Errorf("---- got\n%s---- exp\n%s", g, e)
+	}
+}
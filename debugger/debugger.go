@@ -0,0 +1,948 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package debugger implements a source level debugger of programs executed
+// by the virtual machine.
+//
+// The debugger controls a Machine, eg. a crashed program loaded from a core
+// file, see CoreMachine. Source positions and function names are available for
+// executables compiled with -g.
+//
+// Commands
+//
+// The commands, and their abbreviations, are
+//
+//	backtrace, bt		print the call stack
+//	break, b LOCATION	set a breakpoint
+//	continue, c		run until a breakpoint is hit or the program exits
+//	delete, d [N]		delete breakpoint N or all breakpoints
+//	finish			run until the current function returns
+//	frame, f [N]		select frame N or print the selected frame
+//	help, h			list the commands
+//	info breakpoints	list the breakpoints
+//	info locals		print the parameters and local variables
+//	info registers		print the registers
+//	list, l [LOCATION]	print the source lines around LOCATION
+//	next, n			step over calls to the next source line
+//	nexti, ni		step over calls to the next instruction
+//	print, p NAME		print a variable
+//	ptype NAME		print the type of a variable
+//	quit, q			leave the debugger
+//	stack [N]		print N words of the operand stack
+//	step, s			step to the next source line
+//	stepi, si		step to the next instruction
+//	x[/N] ADDRESS		examine N bytes of memory
+//
+// A LOCATION is a function name, FILE:LINE or *PC. An ADDRESS is a number,
+// the name of a variable or one of the registers $pc, $ap, $bp and $sp of the
+// selected frame. An empty line repeats the previous command.
+//
+// Variables
+//
+// Without debug information, see package debuginfo, print shows global
+// variables as 64 bit words. With debug information, print, ptype and info
+// locals show the parameters and local variables visible in the selected
+// frame and the global variables, formatted according to their C types.
+// Running the program selects the innermost frame.
+package debugger
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cznic/99c/core"
+	"github.com/cznic/99c/debuginfo"
+	"github.com/cznic/virtual"
+)
+
+// Frame is a call frame of a program.
+type Frame struct {
+	AP uint64 // Arguments pointer.
+	BP uint64 // Frame pointer.
+	PC uint64 // Program counter.
+}
+
+// Registers reports whether the argument and frame pointers of f are known.
+// A core file records them only for the innermost frame.
+func (f Frame) Registers() bool { return f.AP != 0 || f.BP != 0 }
+
+// Addr returns the address of v in f. It fails for the parameters and local
+// variables of a frame without known registers.
+func (f Frame) Addr(v *debuginfo.Variable) (uint64, error) {
+	if v.Base != debuginfo.Global && !f.Registers() {
+		return 0, errNoRegisters
+	}
+
+	return v.Addr(f.AP, f.BP), nil
+}
+
+// Machine is a program controlled by the debugger. It is stopped between
+// instructions.
+type Machine interface {
+	// Depth returns the number of call frames. It is called after every
+	// instruction when stepping over calls and must not allocate.
+	Depth() int
+
+	// Frames returns the call frames, the innermost first.
+	Frames() []Frame
+
+	// PC returns the program counter of the innermost frame. It is called
+	// after every instruction and must not allocate.
+	PC() uint64
+
+	// ReadMemory returns n bytes of memory at addr.
+	ReadMemory(addr uint64, n int) ([]byte, error)
+
+	// SP returns the operand stack pointer.
+	SP() uint64
+
+	// Step executes a single instruction. If the program exits, Step
+	// returns true and its exit code.
+	Step() (exited bool, code int, err error)
+}
+
+// CoreMachine returns a Machine inspecting the crashed program c. The
+// machine cannot be stepped.
+func CoreMachine(c *core.Core) Machine { return coreMachine{c} }
+
+type coreMachine struct {
+	c *core.Core
+}
+
+func (m coreMachine) thread() *core.Thread {
+	if len(m.c.Threads) == 0 {
+		return &core.Thread{}
+	}
+
+	return &m.c.Threads[0]
+}
+
+func (m coreMachine) Depth() int { return len(m.thread().Stack) + 1 }
+
+func (m coreMachine) Frames() []Frame {
+	t := m.thread()
+	r := []Frame{{AP: t.AP, BP: t.BP, PC: t.PC}}
+	for _, pc := range t.Stack {
+		r = append(r, Frame{PC: pc})
+	}
+	return r
+}
+
+func (m coreMachine) ReadMemory(addr uint64, n int) ([]byte, error) {
+	return m.c.ReadMemory(addr, n)
+}
+
+func (m coreMachine) PC() uint64 { return m.thread().PC }
+
+func (m coreMachine) SP() uint64 { return m.thread().SP }
+
+func (m coreMachine) Step() (bool, int, error) {
+	return false, 0, errors.New("the program is not running, it crashed: " + m.c.Message)
+}
+
+// ErrQuit is returned by Exec for the quit command.
+var ErrQuit = errors.New("quit")
+
+// errExited is returned by commands requiring a running program.
+var errExited = errors.New("the program is not being run")
+
+// errNoRegisters is returned when accessing the local variables of a frame
+// without known registers.
+var errNoRegisters = errors.New("the core file records the registers of the innermost frame only")
+
+type breakpoint struct {
+	id  int
+	loc string
+	pc  uint64
+}
+
+// Debugger executes debugger commands.
+type Debugger struct {
+	b           *virtual.Binary
+	breakpoints []*breakpoint          // In the order of their numbers.
+	byPC        map[uint64]*breakpoint // The first breakpoint at a PC.
+	code        int
+	exited      bool
+	frame       int
+	info        *debuginfo.Info
+	last        string
+	m           Machine
+	nextID      int
+	w           io.Writer
+}
+
+// New returns a Debugger controlling m, which executes b. Info is the debug
+// information of b, if any. The output of the commands is written to w.
+func New(m Machine, b *virtual.Binary, info *debuginfo.Info, w io.Writer) *Debugger {
+	return &Debugger{b: b, byPC: map[uint64]*breakpoint{}, info: info, m: m, nextID: 1, w: w}
+}
+
+// Run reads commands from r, one per line, and executes them until the quit
+// command or the end of r. Errors of the commands are written to the output
+// of d. If prompt is not empty, it is written before reading a command.
+func (d *Debugger) Run(r io.Reader, prompt string) error {
+	s := bufio.NewScanner(r)
+	for {
+		if prompt != "" {
+			fmt.Fprint(d.w, prompt)
+		}
+		if !s.Scan() {
+			return s.Err()
+		}
+
+		switch err := d.Exec(s.Text()); err {
+		case nil:
+			// ok
+		case ErrQuit:
+			return nil
+		default:
+			fmt.Fprintln(d.w, err)
+		}
+	}
+}
+
+// Exec executes the command line. An empty line repeats the previous
+// command.
+func (d *Debugger) Exec(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		line = d.last
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	d.last = line
+	a := strings.Fields(line)
+	cmd, args := a[0], a[1:]
+	size := 0
+	if i := strings.IndexByte(cmd, '/'); i >= 0 {
+		n, err := strconv.Atoi(cmd[i+1:])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid size: %s", cmd[i+1:])
+		}
+
+		cmd, size = cmd[:i], n
+	}
+	switch cmd {
+	case "backtrace", "bt":
+		return d.backtrace()
+	case "break", "b":
+		if len(args) != 1 {
+			return errors.New("usage: break LOCATION")
+		}
+
+		return d.setBreakpoint(args[0])
+	case "continue", "c":
+		return d.report(d.Continue())
+	case "delete", "d":
+		return d.deleteBreakpoints(args)
+	case "finish":
+		return d.finish()
+	case "frame", "f":
+		return d.selectFrame(args)
+	case "help", "h":
+		fmt.Fprint(d.w, help)
+		return nil
+	case "info":
+		if len(args) == 1 {
+			switch args[0] {
+			case "breakpoints", "b":
+				d.listBreakpoints()
+				return nil
+			case "locals":
+				return d.locals()
+			case "registers", "r":
+				return d.registers()
+			}
+		}
+
+		return errors.New("usage: info breakpoints|locals|registers")
+	case "list", "l":
+		return d.list(args)
+	case "next", "n":
+		return d.report(d.Next())
+	case "nexti", "ni":
+		return d.report(d.NextInstruction())
+	case "print", "p":
+		if len(args) != 1 {
+			return errors.New("usage: print NAME")
+		}
+
+		return d.print(args[0])
+	case "ptype":
+		if len(args) != 1 {
+			return errors.New("usage: ptype NAME")
+		}
+
+		return d.ptype(args[0])
+	case "quit", "q":
+		return ErrQuit
+	case "stack":
+		return d.stack(args)
+	case "step", "s":
+		return d.report(d.Step())
+	case "stepi", "si":
+		return d.report(d.StepInstruction())
+	case "x":
+		if len(args) != 1 {
+			return errors.New("usage: x[/N] ADDRESS")
+		}
+
+		if size == 0 {
+			size = 64
+		}
+		return d.examine(args[0], size)
+	}
+	return fmt.Errorf("unknown command %q, try help", cmd)
+}
+
+const help = `backtrace, bt		print the call stack
+break, b LOCATION	set a breakpoint
+continue, c		run until a breakpoint is hit or the program exits
+delete, d [N]		delete breakpoint N or all breakpoints
+finish			run until the current function returns
+frame, f [N]		select frame N or print the selected frame
+help, h			list the commands
+info breakpoints	list the breakpoints
+info locals		print the parameters and local variables
+info registers		print the registers
+list, l [LOCATION]	print the source lines around LOCATION
+next, n			step over calls to the next source line
+nexti, ni		step over calls to the next instruction
+print, p NAME		print a variable
+ptype NAME		print the type of a variable
+quit, q			leave the debugger
+stack [N]		print N words of the operand stack
+step, s			step to the next source line
+stepi, si		step to the next instruction
+x[/N] ADDRESS		examine N bytes of memory
+
+A LOCATION is a function name, FILE:LINE or *PC. An ADDRESS is a number,
+the name of a variable or one of the registers $pc, $ap, $bp and $sp of the
+selected frame. An empty line repeats the previous command.
+`
+
+// lookup returns the last item of a having PC not greater than pc.
+func lookup(a []virtual.PCInfo, pc uint64) *virtual.PCInfo {
+	i := sort.Search(len(a), func(i int) bool { return uint64(a[i].PC) > pc })
+	if i == 0 {
+		return nil
+	}
+
+	return &a[i-1]
+}
+
+// Symbolize returns the name of the function of b containing pc and the
+// source position of pc, or empty strings if b has no debug information.
+func Symbolize(b *virtual.Binary, pc uint64) (fn, pos string) {
+	f := lookup(b.Functions, pc)
+	if f == nil {
+		return "", ""
+	}
+
+	ln := lookup(b.Lines, pc)
+	if ln == nil {
+		return f.Name.String(), ""
+	}
+
+	return f.Name.String(), fmt.Sprintf("%v:%v:%v", ln.Name, ln.Line, ln.Column)
+}
+
+// Position returns the source position of pc in b, if known.
+func Position(b *virtual.Binary, pc uint64) (file string, line, column int, ok bool) {
+	ln := lookup(b.Lines, pc)
+	if ln == nil {
+		return "", 0, 0, false
+	}
+
+	return ln.Name.String(), ln.Line, ln.Column, true
+}
+
+// where describes pc.
+func (d *Debugger) where(pc uint64) string {
+	switch fn, pos := Symbolize(d.b, pc); {
+	case fn == "":
+		return fmt.Sprintf("%#05x", pc)
+	case pos == "":
+		return fmt.Sprintf("%#05x in %s", pc, fn)
+	default:
+		return fmt.Sprintf("%#05x in %s (%s)", pc, fn, pos)
+	}
+}
+
+// line returns the source line of pc, if known.
+func (d *Debugger) line(pc uint64) (string, int, bool) {
+	ln := lookup(d.b.Lines, pc)
+	if ln == nil {
+		return "", 0, false
+	}
+
+	return ln.Name.String(), ln.Line, true
+}
+
+func (d *Debugger) pc() uint64 { return d.m.PC() }
+
+func (d *Debugger) depth() int { return d.m.Depth() }
+
+// resolve returns the PC of loc.
+func (d *Debugger) resolve(loc string) (uint64, error) {
+	if strings.HasPrefix(loc, "*") {
+		return strconv.ParseUint(loc[1:], 0, 64)
+	}
+
+	if len(d.b.Functions) == 0 {
+		return 0, errors.New("no debug information")
+	}
+
+	if i := strings.LastIndexByte(loc, ':'); i >= 0 {
+		file := loc[:i]
+		n, err := strconv.Atoi(loc[i+1:])
+		if err != nil {
+			return 0, fmt.Errorf("invalid line number: %s", loc)
+		}
+
+		// The first PC of the line, or of the nearest line following it.
+		var best *virtual.PCInfo
+		for i := range d.b.Lines {
+			v := &d.b.Lines[i]
+			if v.Line < n || !sameFile(v.Name.String(), file) {
+				continue
+			}
+
+			if best == nil || v.Line < best.Line || v.Line == best.Line && v.PC < best.PC {
+				best = v
+			}
+		}
+		if best == nil {
+			return 0, fmt.Errorf("no code at %s", loc)
+		}
+
+		return uint64(best.PC), nil
+	}
+
+	for _, v := range d.b.Functions {
+		if v.Name.String() == loc {
+			return uint64(v.PC), nil
+		}
+	}
+	return 0, fmt.Errorf("function %s not found", loc)
+}
+
+// sameFile reports whether the file name recorded in the debug information
+// matches the name given by the user. Editors give absolute paths.
+func sameFile(recorded, given string) bool {
+	return recorded == given || strings.HasSuffix(recorded, "/"+given) || strings.HasSuffix(given, "/"+recorded) || filepath.Base(recorded) == given
+}
+
+// SetBreakpoint sets a breakpoint at the LOCATION loc and returns its number
+// and PC.
+func (d *Debugger) SetBreakpoint(loc string) (int, uint64, error) {
+	pc, err := d.resolve(loc)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	bp := &breakpoint{id: d.nextID, loc: loc, pc: pc}
+	d.nextID++
+	d.breakpoints = append(d.breakpoints, bp)
+	if d.byPC[pc] == nil {
+		d.byPC[pc] = bp
+	}
+	return bp.id, pc, nil
+}
+
+// DeleteBreakpoint deletes the breakpoint number n.
+func (d *Debugger) DeleteBreakpoint(n int) error {
+	for i, v := range d.breakpoints {
+		if v.id == n {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			if d.byPC[v.pc] == v {
+				delete(d.byPC, v.pc)
+				for _, w := range d.breakpoints {
+					if w.pc == v.pc {
+						d.byPC[v.pc] = w
+						break
+					}
+				}
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("no breakpoint number %d", n)
+}
+
+func (d *Debugger) setBreakpoint(loc string) error {
+	n, pc, err := d.SetBreakpoint(loc)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(d.w, "Breakpoint %d at %s\n", n, d.where(pc))
+	return nil
+}
+
+func (d *Debugger) deleteBreakpoints(args []string) error {
+	if len(args) == 0 {
+		d.breakpoints = nil
+		d.byPC = map[uint64]*breakpoint{}
+		return nil
+	}
+
+	for _, arg := range args {
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmt.Errorf("invalid breakpoint number: %s", arg)
+		}
+
+		if err := d.DeleteBreakpoint(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Debugger) listBreakpoints() {
+	if len(d.breakpoints) == 0 {
+		fmt.Fprintln(d.w, "No breakpoints")
+		return
+	}
+
+	for _, v := range d.breakpoints {
+		fmt.Fprintf(d.w, "%d\t%s\t%s\n", v.id, v.loc, d.where(v.pc))
+	}
+}
+
+// Stop describes why the program stopped.
+type Stop struct {
+	Breakpoint int  // Number of the breakpoint hit, zero if none.
+	Code       int  // Exit code of the program.
+	Exited     bool // The program exited.
+}
+
+// run executes instructions until stop returns true, a breakpoint is hit or
+// the program exits. The first instruction is always executed.
+func (d *Debugger) run(stop func() bool) (Stop, error) {
+	if d.exited {
+		return Stop{}, errExited
+	}
+
+	d.frame = 0
+	for {
+		exited, code, err := d.m.Step()
+		if err != nil {
+			return Stop{}, err
+		}
+
+		if exited {
+			d.exited, d.code = true, code
+			return Stop{Code: code, Exited: true}, nil
+		}
+
+		if bp := d.byPC[d.m.PC()]; bp != nil {
+			return Stop{Breakpoint: bp.id}, nil
+		}
+
+		if stop() {
+			return Stop{}, nil
+		}
+	}
+}
+
+// report prints why the program stopped.
+func (d *Debugger) report(s Stop, err error) error {
+	switch {
+	case err != nil:
+		return err
+	case s.Exited:
+		fmt.Fprintf(d.w, "Program exited with code %d\n", s.Code)
+	case s.Breakpoint != 0:
+		fmt.Fprintf(d.w, "Breakpoint %d, %s\n", s.Breakpoint, d.where(d.pc()))
+	default:
+		fmt.Fprintln(d.w, d.where(d.pc()))
+	}
+	return nil
+}
+
+// Continue runs the program until a breakpoint is hit or the program exits.
+func (d *Debugger) Continue() (Stop, error) { return d.run(func() bool { return false }) }
+
+// StepInstruction executes a single instruction.
+func (d *Debugger) StepInstruction() (Stop, error) { return d.run(func() bool { return true }) }
+
+// NextInstruction executes a single instruction, stepping over calls.
+func (d *Debugger) NextInstruction() (Stop, error) {
+	depth := d.depth()
+	return d.run(func() bool { return d.depth() <= depth })
+}
+
+// Step runs the program to the next source line.
+func (d *Debugger) Step() (Stop, error) {
+	file, line, _ := d.line(d.pc())
+	return d.run(func() bool {
+		f, l, ok := d.line(d.pc())
+		return ok && (f != file || l != line)
+	})
+}
+
+// Next runs the program to the next source line, stepping over calls.
+func (d *Debugger) Next() (Stop, error) {
+	depth := d.depth()
+	file, line, _ := d.line(d.pc())
+	return d.run(func() bool {
+		if d.depth() > depth {
+			return false
+		}
+
+		f, l, ok := d.line(d.pc())
+		return ok && (f != file || l != line)
+	})
+}
+
+// Finish runs the program until the current function returns.
+func (d *Debugger) Finish() (Stop, error) {
+	if d.exited {
+		return Stop{}, errExited
+	}
+
+	depth := d.depth()
+	if depth < 2 {
+		return Stop{}, errors.New(`"finish" not meaningful in the outermost frame`)
+	}
+
+	return d.run(func() bool { return d.depth() < depth })
+}
+
+func (d *Debugger) finish() error {
+	if !d.exited && d.depth() >= 2 {
+		fmt.Fprintf(d.w, "Run till exit from %s\n", d.where(d.pc()))
+	}
+	return d.report(d.Finish())
+}
+
+func (d *Debugger) backtrace() error {
+	if d.exited {
+		return errExited
+	}
+
+	for i, v := range d.m.Frames() {
+		fmt.Fprintf(d.w, "#%d  %s\n", i, d.where(v.PC))
+	}
+	return nil
+}
+
+func (d *Debugger) registers() error {
+	if d.exited {
+		return errExited
+	}
+
+	f, err := d.selected()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(d.w, "pc\t%#x\nap\t%#x\nbp\t%#x\nsp\t%#x\n", f.PC, f.AP, f.BP, d.m.SP())
+	return nil
+}
+
+// global returns the address of the global variable name.
+func (d *Debugger) global(name string) (uint64, bool) {
+	for k, v := range d.b.Sym {
+		if k.String() == name {
+			return uint64(v), true
+		}
+	}
+	return 0, false
+}
+
+// address evaluates the ADDRESS expression s.
+func (d *Debugger) address(s string) (uint64, error) {
+	if strings.HasPrefix(s, "$") {
+		f, err := d.selected()
+		if err != nil {
+			return 0, err
+		}
+
+		switch s {
+		case "$pc":
+			return f.PC, nil
+		case "$ap":
+			return f.AP, nil
+		case "$bp":
+			return f.BP, nil
+		case "$sp":
+			return d.m.SP(), nil
+		}
+		return 0, fmt.Errorf("unknown register %s", s)
+	}
+
+	if n, err := strconv.ParseUint(s, 0, 64); err == nil {
+		return n, nil
+	}
+
+	if v, f, ok := d.variable(s); ok {
+		return f.Addr(v)
+	}
+
+	if addr, ok := d.global(s); ok {
+		return addr, nil
+	}
+
+	return 0, fmt.Errorf("no symbol %q", s)
+}
+
+func (d *Debugger) examine(s string, n int) error {
+	if d.exited {
+		return errExited
+	}
+
+	addr, err := d.address(s)
+	if err != nil {
+		return err
+	}
+
+	b, err := d.m.ReadMemory(addr, n)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(b); i += 16 {
+		fmt.Fprintf(d.w, "%#08x:", addr+uint64(i))
+		for _, c := range b[i:min(i+16, len(b))] {
+			fmt.Fprintf(d.w, " %02x", c)
+		}
+		fmt.Fprintln(d.w)
+	}
+	return nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+// selected returns the selected frame.
+func (d *Debugger) selected() (Frame, error) {
+	f := d.m.Frames()
+	if d.frame >= len(f) {
+		return Frame{}, errors.New("no frames")
+	}
+
+	return f[d.frame], nil
+}
+
+func (d *Debugger) selectFrame(args []string) error {
+	if d.exited {
+		return errExited
+	}
+
+	if len(args) != 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 || n >= d.depth() {
+			return fmt.Errorf("no frame %s", args[0])
+		}
+
+		d.frame = n
+	}
+	f, err := d.selected()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(d.w, "#%d  %s\n", d.frame, d.where(f.PC))
+	return nil
+}
+
+// variable returns the variable name visible in the selected frame, which is
+// also returned.
+func (d *Debugger) variable(name string) (*debuginfo.Variable, Frame, bool) {
+	if d.info == nil {
+		return nil, Frame{}, false
+	}
+
+	f, err := d.selected()
+	if err != nil {
+		return nil, Frame{}, false
+	}
+
+	v, ok := d.info.Lookup(name, f.PC)
+	return v, f, ok
+}
+
+// value returns the formatted value of v in frame f.
+func (d *Debugger) value(v *debuginfo.Variable, f Frame) (string, error) {
+	addr, err := f.Addr(v)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := d.m.ReadMemory(addr, int(d.info.Size(v.Type)))
+	if err != nil {
+		return "", err
+	}
+
+	return d.info.Value(v.Type, b), nil
+}
+
+// print prints the variable name. Without debug information only global
+// variables are known and they are printed as 64 bit words.
+func (d *Debugger) print(name string) error {
+	if d.exited {
+		return errExited
+	}
+
+	if v, f, ok := d.variable(name); ok {
+		s, err := d.value(v, f)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(d.w, "%s = %s\n", name, s)
+		return nil
+	}
+
+	addr, ok := d.global(name)
+	if !ok {
+		return fmt.Errorf("no symbol %q", name)
+	}
+
+	b, err := d.m.ReadMemory(addr, 8)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(d.w, "%s at %#08x = %#016x\n", name, addr, binary.LittleEndian.Uint64(b))
+	return nil
+}
+
+func (d *Debugger) ptype(name string) error {
+	if d.info == nil {
+		return errors.New("no debug information")
+	}
+
+	v, _, ok := d.variable(name)
+	if !ok {
+		return fmt.Errorf("no symbol %q", name)
+	}
+
+	fmt.Fprintf(d.w, "type = %s\n", d.info.Definition(v.Type))
+	return nil
+}
+
+// locals prints the parameters and local variables visible in the selected
+// frame.
+func (d *Debugger) locals() error {
+	if d.exited {
+		return errExited
+	}
+
+	if d.info == nil {
+		return errors.New("no debug information")
+	}
+
+	f, err := d.selected()
+	if err != nil {
+		return err
+	}
+
+	fn := d.info.Function(f.PC)
+	if fn == nil {
+		return fmt.Errorf("no function at %#05x", f.PC)
+	}
+
+	vars := fn.Scope(f.PC)
+	if len(vars) == 0 {
+		fmt.Fprintln(d.w, "No locals.")
+		return nil
+	}
+
+	if !f.Registers() {
+		return errNoRegisters
+	}
+
+	for i := range vars {
+		s, err := d.value(&vars[i], f)
+		if err != nil {
+			s = fmt.Sprintf("<%v>", err)
+		}
+		fmt.Fprintf(d.w, "%s = %s\n", vars[i].Name, s)
+	}
+	return nil
+}
+
+func (d *Debugger) stack(args []string) error {
+	if d.exited {
+		return errExited
+	}
+
+	n := 8
+	if len(args) != 0 {
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil || n <= 0 {
+			return fmt.Errorf("invalid count: %s", args[0])
+		}
+	}
+
+	sp := d.m.SP()
+	for i := 0; i < n; i++ {
+		addr := sp + uint64(8*i)
+		b, err := d.m.ReadMemory(addr, 8)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(d.w, "%#08x: %#016x\n", addr, binary.LittleEndian.Uint64(b))
+	}
+	return nil
+}
+
+// list prints the source lines around loc or the current position.
+func (d *Debugger) list(args []string) error {
+	pc := d.pc()
+	if len(args) != 0 {
+		var err error
+		if pc, err = d.resolve(args[0]); err != nil {
+			return err
+		}
+	}
+
+	file, line, ok := d.line(pc)
+	if !ok {
+		return fmt.Errorf("no source position of %#05x", pc)
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(string(b), "\n")
+	for i := max(line-5, 1); i <= line+5 && i <= len(lines); i++ {
+		mark := " "
+		if i == line {
+			mark = ">"
+		}
+		fmt.Fprintf(d.w, "%s%5d\t%s\n", mark, i, lines[i-1])
+	}
+	return nil
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,216 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debuginfo
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func caller(s string, va ...interface{}) {
+	if s == "" {
+		s = strings.Repeat("%v ", len(va))
+	}
+	_, fn, fl, _ := runtime.Caller(2)
+	fmt.Fprintf(os.Stderr, "# caller: %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintf(os.Stderr, s, va...)
+	fmt.Fprintln(os.Stderr)
+	_, fn, fl, _ = runtime.Caller(1)
+	fmt.Fprintf(os.Stderr, "# \tcallee: %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintln(os.Stderr)
+	os.Stderr.Sync()
+}
+
+func dbg(s string, va ...interface{}) {
+	if s == "" {
+		s = strings.Repeat("%v ", len(va))
+	}
+	_, fn, fl, _ := runtime.Caller(1)
+	fmt.Fprintf(os.Stderr, "# dbg %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintf(os.Stderr, s, va...)
+	fmt.Fprintln(os.Stderr)
+	os.Stderr.Sync()
+}
+
+func TODO(...interface{}) string { //TODOOK
+	_, fn, fl, _ := runtime.Caller(1)
+	return fmt.Sprintf("# TODO: %s:%d:\n", path.Base(fn), fl) //TODOOK
+}
+
+func use(...interface{}) {}
+
+func init() {
+	use(caller, dbg, TODO) //TODOOK
+}
+
+// ============================================================================
+
+// testInfo describes
+//
+//	typedef struct point { int x, y; } point;
+//	enum color { red, green = 5 };
+//	struct flags { unsigned a : 3; int b : 4; };
+//	char name[8];
+//	double ratio;
+//	int f(point *p) { enum color c; { point q; } }
+var testInfo = &Info{
+	Types: []Type{
+		{Kind: Int, Name: "int", Size: 4, Align: 4}, // 0
+		{Kind: Struct, Name: "struct point", Size: 8, Align: 4, Fields: []Field{{Name: "x"}, {Name: "y", Offset: 4}}}, // 1
+		{Kind: Typedef, Name: "point", Elem: 1},     // 2
+		{Kind: Pointer, Size: 8, Align: 8, Elem: 2}, // 3
+		{Kind: Enum, Name: "enum color", Size: 4, Align: 4, Enumerators: []Enumerator{{"red", 0}, {"green", 5}}}, // 4
+		{Kind: Int, Name: "char", Size: 1, Align: 1},                                                             // 5
+		{Kind: Array, Size: 8, Align: 1, Elem: 5, Len: 8},                                                        // 6
+		{Kind: Float, Name: "double", Size: 8, Align: 8},                                                         // 7
+		{Kind: Func, Elem: 0},                             // 8
+		{Kind: Uint, Name: "unsigned", Size: 4, Align: 4}, // 9
+		{Kind: Struct, Name: "struct flags", Size: 4, Align: 4, Fields: []Field{{Name: "a", Type: 9, Bits: 3}, {Name: "b", Type: 0, BitOffset: 3, Bits: 4}}}, // 10
+		{Kind: Array, Size: 8, Align: 4, Elem: 0, Len: 2}, // 11
+	},
+	Globals: []Variable{
+		{Name: "name", Offset: 0x1000, Type: 6},
+		{Name: "ratio", Offset: 0x1008, Type: 7},
+		{Name: "c", Offset: 0x1010, Type: 0},
+	},
+	Functions: []Function{
+		{
+			Name: "f", PC: 0x10, End: 0x20, Type: 8,
+			Params: []Variable{{Name: "p", Base: AP, Offset: 8, Type: 3}},
+			Locals: []Variable{
+				{Name: "c", Base: BP, Offset: -4, PC: 0x11, End: 0x1f, Type: 4},
+				{Name: "p", Base: BP, Offset: -12, PC: 0x14, End: 0x18, Type: 2},
+			},
+		},
+	},
+}
+
+func TestLookup(t *testing.T) {
+	for i, v := range []struct {
+		name string
+		pc   uint64
+		addr uint64
+		typ  string
+	}{
+		{"c", 0x05, 0x1010, "int"},
+		{"c", 0x10, 0x1010, "int"},
+		{"c", 0x11, 0x1ffc, "enum color"},
+		{"p", 0x11, 0x3008, "point *"},
+		{"p", 0x14, 0x1ff4, "point"},
+		{"p", 0x18, 0x3008, "point *"},
+		{"name", 0x14, 0x1000, "char [8]"},
+		{"q", 0x14, 0, ""},
+	} {
+		w, ok := testInfo.Lookup(v.name, v.pc)
+		if !ok {
+			if v.typ != "" {
+				t.Errorf("%v: %s not found", i, v.name)
+			}
+			continue
+		}
+
+		if g, e := w.Addr(0x3000, 0x2000), v.addr; g != e {
+			t.Errorf("%v: got %#x, expected %#x", i, g, e)
+		}
+		if g, e := testInfo.TypeString(w.Type), v.typ; g != e {
+			t.Errorf("%v: got %q, expected %q", i, g, e)
+		}
+	}
+}
+
+func TestValue(t *testing.T) {
+	for i, v := range []struct {
+		typ int
+		b   string
+		s   string
+	}{
+		{0, "\xfe\xff\xff\xff", "-2"},
+		{2, "\x01\x00\x00\x00\x02\x00\x00\x00", "{x = 1, y = 2}"},
+		{3, "\x10\x20\x00\x00\x00\x00\x00\x00", "(point *) 0x2010"},
+		{4, "\x05\x00\x00\x00", "green"},
+		{4, "\x07\x00\x00\x00", "7"},
+		{5, "A", "65 'A'"},
+		{6, "abc\x00xxxx", `"abc"`},
+		{7, "\x00\x00\x00\x00\x00\x00\xf8\x3f", "1.5"},
+		{9, "\x01\x00\x00", "<unavailable>"},
+		{10, "\x7d\x00\x00\x00", "{a = 5, b = -1}"},
+		{11, "\x01\x00\x00\x00\x02\x00\x00\x00", "{1, 2}"},
+	} {
+		if g, e := testInfo.Value(v.typ, []byte(v.b)), v.s; g != e {
+			t.Errorf("%v: got %q, expected %q", i, g, e)
+		}
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	for i, v := range []struct {
+		typ int
+		s   string
+	}{
+		{0, "int"},
+		{2, "struct point {\n    int x;\n    int y;\n}"},
+		{4, "enum color { red = 0, green = 5 }"},
+		{10, "struct flags {\n    unsigned a : 3;\n    int b : 4;\n}"},
+	} {
+		if g, e := testInfo.Definition(v.typ), v.s; g != e {
+			t.Errorf("%v: got %q, expected %q", i, g, e)
+		}
+	}
+}
+
+func TestMembers(t *testing.T) {
+	b := []byte("\x7d\x00\x00\x00")
+	var a []string
+	for _, f := range testInfo.Members(10) {
+		a = append(a, f.Name+"="+testInfo.FieldValue(f, b))
+	}
+	if g, e := strings.Join(a, " "), "a=5 b=-1"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	a = nil
+	for _, f := range testInfo.Members(11) {
+		a = append(a, fmt.Sprintf("%s@%d:%s", f.Name, f.Offset, testInfo.TypeString(f.Type)))
+	}
+	if g, e := strings.Join(a, " "), "[0]@0:int [1]@4:int"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	if m := testInfo.Members(0); m != nil {
+		t.Fatal(m)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := testInfo.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, v := range []struct {
+		info *Info
+		err  string
+	}{
+		{&Info{Types: []Type{{Kind: Pointer, Size: 8, Elem: 1}}}, "invalid type 1"},
+		{&Info{Types: []Type{{Kind: Struct, Size: 4, Fields: []Field{{Name: "x", Type: -1}}}}}, "field x: invalid type -1"},
+		{&Info{Types: []Type{{Kind: Int, Size: -1}}}, "invalid size"},
+		{&Info{Types: []Type{{Kind: Pointer, Size: 8}}}, "refers to itself"},
+		{&Info{Types: []Type{{Kind: Typedef, Elem: 1}, {Kind: Typedef}}}, "refers to itself"},
+		{&Info{Types: []Type{{Kind: Int, Size: 4}, {Kind: Array, Size: 8, Elem: 0, Len: 3}}}, "3 elements of size 4 exceed the size 8"},
+		{&Info{Types: []Type{{Kind: Void}, {Kind: Array, Size: 8, Elem: 0, Len: 1 << 40}}}, "exceed the size 8"},
+		{&Info{Types: []Type{{Kind: Float, Size: 2}}}, "invalid float size 2"},
+		{&Info{Types: []Type{{Kind: Int, Size: 4}, {Kind: Struct, Size: 4, Fields: []Field{{Name: "y", Offset: 2}}}}}, "field y at offset 2 of size 4 exceeds the size 4"},
+		{&Info{Types: []Type{{Kind: Int, Size: 4}, {Kind: Struct, Size: 4, Fields: []Field{{Name: "b", BitOffset: 30, Bits: 3}}}}}, "field b: invalid bit field 30:3"},
+		{&Info{Types: []Type{{Kind: Int, Size: 4}}, Globals: []Variable{{Name: "g", Type: 2}}}, "g: invalid type 2"},
+		{&Info{Types: []Type{{Kind: Int, Size: 4}}, Functions: []Function{{Name: "f", Locals: []Variable{{Name: "l", Type: 5}}}}}, "f.l: invalid type 5"},
+	} {
+		if err := v.info.Validate(); err == nil || !strings.Contains(err.Error(), v.err) {
+			t.Errorf("%v: got %v, expected %q", i, err, v.err)
+		}
+	}
+}
@@ -0,0 +1,563 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package debuginfo describes the variables and types of programs executed by
+// the virtual machine.
+//
+// The information is stored in the header of the executable, see exe.Header.
+// 99c does not produce it, neither ccir nor the virtual machine provide a way
+// to do so.
+//
+// Types are referred to by their index in Info.Types. Variables are located
+// relative to a base, ie. at an absolute address for global variables, above
+// the argument pointer for parameters and below the frame pointer for local
+// variables.
+package debuginfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Kind is the kind of a type.
+type Kind int
+
+// Values of type Kind.
+const (
+	Invalid Kind = iota
+	Array        // Elem[Len].
+	Enum         // Integer with Enumerators.
+	Float        // float, double or long double.
+	Func         // Function returning Elem.
+	Int          // Signed integer, including signed char.
+	Pointer      // Pointer to Elem.
+	Struct       // Fields.
+	Typedef      // Name for Elem.
+	Uint         // Unsigned integer, including char, unsigned char and _Bool.
+	Union        // Fields.
+	Void
+)
+
+var kinds = [...]string{
+	Invalid: "Invalid",
+	Array:   "Array",
+	Enum:    "Enum",
+	Float:   "Float",
+	Func:    "Func",
+	Int:     "Int",
+	Pointer: "Pointer",
+	Struct:  "Struct",
+	Typedef: "Typedef",
+	Uint:    "Uint",
+	Union:   "Union",
+	Void:    "Void",
+}
+
+func (k Kind) String() string {
+	if k >= 0 && int(k) < len(kinds) {
+		return kinds[k]
+	}
+
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Type describes a C type.
+type Type struct {
+	Align       int64        `json:",omitempty"`
+	Elem        int          `json:",omitempty"` // Array element, enum underlying type, function result, pointee or typedef target.
+	Enumerators []Enumerator `json:",omitempty"`
+	Fields      []Field      `json:",omitempty"`
+	Kind        Kind
+	Len         int64  `json:",omitempty"` // Array length.
+	Name        string `json:",omitempty"` // Eg. "int", "struct point" or the name of a typedef.
+	Size        int64  `json:",omitempty"`
+}
+
+// Field is a member of a struct or union.
+type Field struct {
+	BitOffset int `json:",omitempty"` // Bit fields only.
+	Bits      int `json:",omitempty"` // Width of a bit field, zero otherwise.
+	Name      string
+	Offset    int64 `json:",omitempty"`
+	Type      int
+}
+
+// Enumerator is an enumeration constant.
+type Enumerator struct {
+	Name  string
+	Value int64
+}
+
+// Base is what the offset of a variable is relative to.
+type Base int
+
+// Values of type Base.
+const (
+	Global Base = iota // The offset is the address of the variable.
+	AP                 // The offset is relative to the argument pointer.
+	BP                 // The offset is relative to the frame pointer.
+)
+
+// Variable describes a C variable.
+type Variable struct {
+	Base   Base `json:",omitempty"`
+	End    int  `json:",omitempty"` // End of the scope, exclusive. Locals only.
+	Name   string
+	Offset int64 `json:",omitempty"`
+	PC     int   `json:",omitempty"` // Start of the scope. Locals only.
+	Type   int
+}
+
+// Addr returns the address of v in a frame with the argument pointer ap and
+// the frame pointer bp.
+func (v *Variable) Addr(ap, bp uint64) uint64 {
+	switch v.Base {
+	case AP:
+		return ap + uint64(v.Offset)
+	case BP:
+		return bp + uint64(v.Offset)
+	}
+	return uint64(v.Offset)
+}
+
+// Function describes a C function. Its code occupies the PCs in [PC, End).
+type Function struct {
+	End    int
+	Locals []Variable `json:",omitempty"` // Ordered by the start of their scope.
+	Name   string
+	PC     int
+	Params []Variable `json:",omitempty"`
+	Type   int
+}
+
+// Scope returns the parameters of f and its local variables in scope at pc.
+// A variable shadowed by another one of the same name is omitted.
+func (f *Function) Scope(pc uint64) []Variable {
+	r := append([]Variable(nil), f.Params...)
+	for _, v := range f.Locals {
+		if pc < uint64(v.PC) || pc >= uint64(v.End) {
+			continue
+		}
+
+		for i, w := range r {
+			if w.Name == v.Name {
+				r = append(r[:i], r[i+1:]...)
+				break
+			}
+		}
+		r = append(r, v)
+	}
+	return r
+}
+
+// Info is the debug information of an executable.
+type Info struct {
+	Functions []Function `json:",omitempty"` // Ordered by PC.
+	Globals   []Variable `json:",omitempty"`
+	Types     []Type
+}
+
+// Validate checks that the type indexes, sizes and field offsets in i are
+// consistent, so that the methods of i stay within Types and within the
+// memory of the values they format. The debug information of an executable
+// is validated when its header is read, see exe.Read.
+func (i *Info) Validate() error {
+	typ := func(t int) error {
+		if t < 0 || t >= len(i.Types) {
+			return fmt.Errorf("invalid type %d", t)
+		}
+
+		return nil
+	}
+
+	for j := range i.Types {
+		v := &i.Types[j]
+		if v.Size < 0 || v.Len < 0 {
+			return fmt.Errorf("type %d: invalid size %d or length %d", j, v.Size, v.Len)
+		}
+
+		if err := typ(v.Elem); err != nil {
+			return fmt.Errorf("type %d: %v", j, err)
+		}
+
+		for _, f := range v.Fields {
+			if err := typ(f.Type); err != nil {
+				return fmt.Errorf("type %d: field %s: %v", j, f.Name, err)
+			}
+		}
+	}
+
+	// TypeString follows the elements of arrays, functions and pointers,
+	// formatting a value follows typedefs, the elements of arrays and the
+	// fields of structs and unions.
+	if err := i.acyclic(func(v *Type) []int {
+		switch v.Kind {
+		case Array, Func, Pointer:
+			return []int{v.Elem}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := i.acyclic(func(v *Type) []int {
+		switch v.Kind {
+		case Array, Typedef:
+			return []int{v.Elem}
+		case Struct, Union:
+			var r []int
+			for _, f := range v.Fields {
+				r = append(r, f.Type)
+			}
+			return r
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for j := range i.Types {
+		switch v := &i.Types[j]; v.Kind {
+		case Array:
+			// Elements of size zero are limited like bytes, bounding the
+			// number of members.
+			elem := i.Size(v.Elem)
+			if v.Len > v.Size/max64(elem, 1) {
+				return fmt.Errorf("type %d: %d elements of size %d exceed the size %d", j, v.Len, elem, v.Size)
+			}
+		case Float:
+			if v.Size != 4 && v.Size < 8 {
+				return fmt.Errorf("type %d: invalid float size %d", j, v.Size)
+			}
+		case Struct, Union:
+			for _, f := range v.Fields {
+				size := i.Size(f.Type)
+				if f.Offset < 0 || f.Offset > v.Size || size > v.Size-f.Offset {
+					return fmt.Errorf("type %d: field %s at offset %d of size %d exceeds the size %d", j, f.Name, f.Offset, size, v.Size)
+				}
+
+				if f.Bits < 0 || f.Bits != 0 && (size < 1 || size > 8 || f.BitOffset < 0 || int64(f.BitOffset) > 8*size-int64(f.Bits)) {
+					return fmt.Errorf("type %d: field %s: invalid bit field %d:%d", j, f.Name, f.BitOffset, f.Bits)
+				}
+			}
+		}
+	}
+
+	vars := func(s string, a []Variable) error {
+		for _, v := range a {
+			if err := typ(v.Type); err != nil {
+				return fmt.Errorf("%s%s: %v", s, v.Name, err)
+			}
+		}
+		return nil
+	}
+
+	if err := vars("", i.Globals); err != nil {
+		return err
+	}
+
+	for _, f := range i.Functions {
+		if err := typ(f.Type); err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+
+		if err := vars(f.Name+".", f.Params); err != nil {
+			return err
+		}
+
+		if err := vars(f.Name+".", f.Locals); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// acyclic checks that the types referred to by the types in i, as reported by
+// refs, do not refer back to themselves.
+func (i *Info) acyclic(refs func(*Type) []int) error {
+	const (
+		visiting = iota + 1
+		visited
+	)
+
+	state := make([]byte, len(i.Types))
+	var visit func(int) error
+	visit = func(t int) error {
+		switch state[t] {
+		case visiting:
+			return fmt.Errorf("type %d refers to itself", t)
+		case visited:
+			return nil
+		}
+
+		state[t] = visiting
+		for _, r := range refs(&i.Types[t]) {
+			if err := visit(r); err != nil {
+				return err
+			}
+		}
+		state[t] = visited
+		return nil
+	}
+
+	for t := range i.Types {
+		if err := visit(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function returns the function containing pc or nil if there's no such
+// function.
+func (i *Info) Function(pc uint64) *Function {
+	for j := range i.Functions {
+		if f := &i.Functions[j]; pc >= uint64(f.PC) && pc < uint64(f.End) {
+			return f
+		}
+	}
+	return nil
+}
+
+// Lookup returns the variable name visible at pc, preferring local variables
+// and parameters to global variables.
+func (i *Info) Lookup(name string, pc uint64) (*Variable, bool) {
+	if f := i.Function(pc); f != nil {
+		for _, v := range f.Scope(pc) {
+			if v.Name == name {
+				return &v, true
+			}
+		}
+	}
+	for j := range i.Globals {
+		if v := &i.Globals[j]; v.Name == name {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// Size returns the size of type t in bytes.
+func (i *Info) Size(t int) int64 { return i.Types[i.resolve(t)].Size }
+
+// resolve returns t with typedefs removed.
+func (i *Info) resolve(t int) int {
+	for n := 0; i.Types[t].Kind == Typedef && n < len(i.Types); n++ {
+		t = i.Types[t].Elem
+	}
+	return t
+}
+
+// TypeString returns the C name of type t.
+func (i *Info) TypeString(t int) string {
+	switch v := &i.Types[t]; v.Kind {
+	case Array:
+		return fmt.Sprintf("%s [%d]", i.TypeString(v.Elem), v.Len)
+	case Func:
+		return fmt.Sprintf("%s ()", i.TypeString(v.Elem))
+	case Pointer:
+		return i.TypeString(v.Elem) + " *"
+	default:
+		if v.Name == "" {
+			return v.Kind.String()
+		}
+
+		return v.Name
+	}
+}
+
+// Definition returns the C definition of type t, listing the fields of
+// structs and unions and the constants of enums.
+func (i *Info) Definition(t int) string {
+	v := &i.Types[i.resolve(t)]
+	var buf bytes.Buffer
+	switch v.Kind {
+	case Enum:
+		fmt.Fprintf(&buf, "%s {", v.Name)
+		for j, e := range v.Enumerators {
+			if j != 0 {
+				buf.WriteString(",")
+			}
+			fmt.Fprintf(&buf, " %s = %d", e.Name, e.Value)
+		}
+		buf.WriteString(" }")
+	case Struct, Union:
+		fmt.Fprintf(&buf, "%s {\n", v.Name)
+		for _, f := range v.Fields {
+			fmt.Fprintf(&buf, "    %s %s", i.TypeString(f.Type), f.Name)
+			if f.Bits != 0 {
+				fmt.Fprintf(&buf, " : %d", f.Bits)
+			}
+			buf.WriteString(";\n")
+		}
+		buf.WriteString("}")
+	default:
+		return i.TypeString(t)
+	}
+	return buf.String()
+}
+
+// Value formats b, the memory of a value of type t, like gdb does.
+func (i *Info) Value(t int, b []byte) string {
+	var buf bytes.Buffer
+	i.value(&buf, t, b)
+	return buf.String()
+}
+
+func (i *Info) value(buf *bytes.Buffer, t int, b []byte) {
+	v := &i.Types[i.resolve(t)]
+	if int64(len(b)) < v.Size {
+		buf.WriteString("<unavailable>")
+		return
+	}
+
+	switch v.Kind {
+	case Array:
+		elem := i.Size(v.Elem)
+		if e := &i.Types[i.resolve(v.Elem)]; elem == 1 && e.Kind != Enum && (e.Name == "char" || e.Name == "signed char" || e.Name == "unsigned char") {
+			s := b[:v.Len]
+			if n := bytes.IndexByte(s, 0); n >= 0 {
+				s = s[:n]
+			}
+			buf.WriteString(strconv.Quote(string(s)))
+			return
+		}
+
+		buf.WriteByte('{')
+		for j := int64(0); j < v.Len; j++ {
+			if j != 0 {
+				buf.WriteString(", ")
+			}
+			i.value(buf, v.Elem, b[j*elem:(j+1)*elem])
+		}
+		buf.WriteByte('}')
+	case Enum:
+		n := signed(b[:v.Size])
+		for _, e := range v.Enumerators {
+			if e.Value == n {
+				buf.WriteString(e.Name)
+				return
+			}
+		}
+
+		fmt.Fprint(buf, n)
+	case Float:
+		switch v.Size {
+		case 4:
+			fmt.Fprint(buf, math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		default:
+			fmt.Fprint(buf, math.Float64frombits(binary.LittleEndian.Uint64(b)))
+		}
+	case Func:
+		buf.WriteString("{" + i.TypeString(t) + "}")
+	case Int:
+		n := signed(b[:v.Size])
+		fmt.Fprint(buf, n)
+		if v.Size == 1 {
+			fmt.Fprintf(buf, " %s", strconv.QuoteRune(rune(uint8(n))))
+		}
+	case Pointer:
+		fmt.Fprintf(buf, "(%s) %#x", i.TypeString(t), unsigned(b[:v.Size]))
+	case Struct, Union:
+		buf.WriteByte('{')
+		for j, f := range v.Fields {
+			if j != 0 {
+				buf.WriteString(", ")
+			}
+			fmt.Fprintf(buf, "%s = ", f.Name)
+			i.field(buf, f, b)
+		}
+		buf.WriteByte('}')
+	case Uint:
+		n := unsigned(b[:v.Size])
+		fmt.Fprint(buf, n)
+		if v.Size == 1 && v.Name != "_Bool" {
+			fmt.Fprintf(buf, " %s", strconv.QuoteRune(rune(n)))
+		}
+	case Void:
+		buf.WriteString("void")
+	default:
+		fmt.Fprintf(buf, "<%v>", v.Kind)
+	}
+}
+
+// FieldValue formats the field f of the struct or union whose memory is b.
+func (i *Info) FieldValue(f Field, b []byte) string {
+	var buf bytes.Buffer
+	i.field(&buf, f, b)
+	return buf.String()
+}
+
+func (i *Info) field(buf *bytes.Buffer, f Field, b []byte) {
+	fb := b[f.Offset:]
+	if f.Bits == 0 {
+		i.value(buf, f.Type, fb)
+		return
+	}
+
+	n := unsigned(fb[:i.Size(f.Type)]) >> uint(f.BitOffset) & (1<<uint(f.Bits) - 1)
+	if i.Types[i.resolve(f.Type)].Kind == Int && n&(1<<uint(f.Bits-1)) != 0 {
+		fmt.Fprint(buf, int64(n)-1<<uint(f.Bits))
+		return
+	}
+
+	fmt.Fprint(buf, n)
+}
+
+// Underlying returns type t with typedefs removed.
+func (i *Info) Underlying(t int) *Type { return &i.Types[i.resolve(t)] }
+
+// Element returns the element j of an array of type t as a member named [j].
+func (i *Info) Element(t int, j int64) Field {
+	elem := i.Types[i.resolve(t)].Elem
+	return Field{Name: fmt.Sprintf("[%d]", j), Offset: j * i.Size(elem), Type: elem}
+}
+
+// Members returns the members of a value of type t, ie. the fields of a
+// struct or union or the elements of an array, named [0], [1] and so on.
+// Values of other types have no members.
+func (i *Info) Members(t int) []Field {
+	switch v := &i.Types[i.resolve(t)]; v.Kind {
+	case Array:
+		var r []Field
+		for j := int64(0); j < v.Len; j++ {
+			r = append(r, i.Element(t, j))
+		}
+		return r
+	case Struct, Union:
+		return v.Fields
+	}
+	return nil
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+// unsigned decodes a little endian unsigned integer.
+func unsigned(b []byte) uint64 {
+	var n uint64
+	for j := len(b) - 1; j >= 0; j-- {
+		n = n<<8 | uint64(b[j])
+	}
+	return n
+}
+
+// signed decodes a little endian two's complement integer.
+func signed(b []byte) int64 {
+	n := unsigned(b)
+	if len(b) == 0 || len(b) >= 8 {
+		return int64(n)
+	}
+
+	shift := uint(64 - 8*len(b))
+	return int64(n<<shift) >> shift
+}
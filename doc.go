@@ -11,6 +11,17 @@
 //     99c: Flags:
 //       -99lib
 //             Library link mode.
+//       -99noconfig
+//             Do not read the configuration file.
+//       -99noshebang
+//             Do not start Linux executables with a #!/usr/bin/env 99run line.
+//             Such executables are run by 99run registered with binfmt_misc.
+//       -99showconfig
+//             Print the effective configuration and exit.
+//       -Bprefix
+//             Add prefix, or a list of prefixes, to the 99c package prefixes.
+//             The include and lib subdirectories of every prefix are added to the
+//             system include files search paths and the search paths for -l.
 //       -Dname
 //             Equivalent to inserting '#define name 1' at the start of the
 //             translation unit.
@@ -28,11 +39,24 @@
 //             Optimization setting, ignored.
 //       -Wwarn
 //             Warning level, ignored.
+//       --prefix list
+//             Same as -Blist.
 //       -ansi
 //             Ignored.
 //       -c    Suppress the link-edit phase of the compilation, and do not
 //             remove any object files that are produced.
-//       -g    Produce debugging information.
+//       -fdebug-prefix-map=old=new
+//             Same as -ffile-prefix-map.
+//       -ffile-prefix-map=old=new
+//             Replace the prefix old of file names recorded in object files and
+//             executables by new. The expansions of __FILE__ are rewritten as
+//             well. Can be given multiple times, the last matching mapping
+//             wins.
+//       -fno-<extension>
+//             Disable the -99extra extension, even if enabled by -std, -99extra
+//             or the configuration file.
+//       -g    Produce debugging information. The executable includes the
+//             function and line information, used by stack traces and 99dbg.
 //       -l<name>
 //             Link with lib<name>.
 //       -o pathname
@@ -42,7 +66,9 @@
 //       -pedantic
 //             Ignored.
 //       -pthread
-//             Ignored. (TODO)
+//             Define _REENTRANT and link with -lpthread. The library is installed
+//             by go generate github.com/cznic/99c/lib/pthread. Programs are single
+//             threaded, pthread_create fails with EAGAIN.
 //       -rdynamic
 //             Ignored. (TODO)
 //       -rpath pathname
@@ -51,6 +77,18 @@
 //             Link mode shared library.
 //       -soname arg
 //             Ignored. (TODO)
+//       -std=standard
+//             Select the language dialect: c89, c99, c11, gnu89, gnu99 or gnu11.
+//             Every dialect enables a curated set of -99extra extensions and
+//             defines __STDC_VERSION__ accordingly. The gnu dialects enable
+//             all the GNU compatible extensions and define __GNUC__.
+//       -target GOOS/GOARCH
+//             Compile for the specified target instead of the host. The
+//             IR and the virtual machine use the data layout of the host,
+//             so only targets with the same pointer and long sizes and the
+//             same alignment of 64 bit types are accepted. In practice
+//             that is the host target only; cross compiling is not yet
+//             supported.
 //       -99extra flag
 //          Extra cc flags:
 //             AlignOf
@@ -101,6 +139,62 @@
 //
 // Changelog
 //
+// 2026-10-19: Executables compiled with -g no longer describe the variables and
+// types of the program. Neither ccir nor the virtual machine provide a way to
+// produce the information.
+//
+// 2026-10-19: Remove the -fsanitize, -fno-sanitize, -fsanitize-recover and
+// -fno-sanitize-recover flags. ccir provides no way to emit the checks.
+//
+// 2026-10-19: Remove the -race flag. The virtual machine provides no way to
+// observe the memory accesses of the program.
+//
+// 2026-10-19: -pthread links with the libpthread installed by package
+// lib/pthread. The virtual machine provides no way for a C program to start a
+// thread, programs are single threaded.
+//
+// 2026-10-18: Programs compiled using -pthread can create threads, see package
+// pthread.
+//
+// 2026-10-18: With -g the executable describes the variables and types of the
+// program, which 99dbg uses to print variables.
+//
+// 2026-10-18: Add the -fsanitize, -fno-sanitize, -fsanitize-recover and
+// -fno-sanitize-recover flags checking for undefined behavior at run time.
+//
+// 2026-10-18: Add the -race flag enabling the race detector of 99run.
+//
+// 2026-10-18: The -pthread flag defines _REENTRANT.
+//
+// 2026-10-18: Add the -99noshebang flag producing executables run by 99run
+// registered with binfmt_misc.
+//
+// 2026-10-18: Add the -ffile-prefix-map and -fdebug-prefix-map flags.
+// Executables include a build ID computed from their content.
+//
+// 2026-10-18: Add the -std flag selecting a language dialect and the
+// -fno-<extension> flag disabling individual extensions.
+//
+// 2026-10-18: Default flags can be set in a per-project configuration file. See
+// the Configuration file section for details.
+//
+// 2026-10-19: -ffile-prefix-map rewrites the expansions of __FILE__ and the
+// positions stored in unexported fields.
+//
+// 2026-10-19: The configuration file accepts the noextra and prefix keys.
+// -99showconfig prints every configuration key.
+//
+// 2026-10-19: The -target flag rejects targets whose data layout differs from
+// the host.
+//
+// 2026-10-18: Add the -B and --prefix flags and the NINETYNINEC_ROOT
+// environment variable to set the 99c package prefixes. The lib directories of
+// the prefixes are now correctly searched for -l.
+//
+// 2026-10-18: Add the -target flag. Executables record the target they were
+// compiled for and 99run refuses to execute executables compiled for a
+// different target.
+//
 // 2017-10-19: Handle ar files (.a).
 //
 // 2017-10-18: Executables should be from now on no more tied to a single
@@ -156,6 +250,18 @@
 //	hello world
 //	C:\>
 //
+// To run a binary on a computer without the 99c tools installed, turn it into
+// a native executable using 99pack.
+//
+// Executables compiled with -99noshebang can be run directly on Linux after
+// registering 99run with binfmt_misc. See the 99run documentation for
+// details.
+//
+//	$ sudo 99run -install-binfmt
+//	$ 99c -99noshebang hello.c && ./a.out
+//	hello world
+//	$
+//
 // A simple program
 //
 // All in just a single C file.
@@ -369,6 +475,7 @@
 //		"strings"
 //		"time"
 //
+//		"github.com/cznic/99c/exe"
 //		"github.com/cznic/httpfs"
 //		"github.com/cznic/virtual"
 //	)
@@ -380,13 +487,13 @@
 //			panic(err)
 //		}
 //
-//		var bin virtual.Binary
-//		if _, err := bin.ReadFrom(f); err != nil {
+//		_, bin, err := exe.Read(f)
+//		if err != nil {
 //			panic(err)
 //		}
 //
 //		var out bytes.Buffer
-//		exitCode, err := virtual.Exec(&bin, nil, strings.NewReader("Foo Bar"), &out, &out, 0, 1<<20, "")
+//		exitCode, err := virtual.Exec(bin, nil, strings.NewReader("Foo Bar"), &out, &out, 0, 1<<20, "")
 //		if err != nil {
 //			panic(err)
 //		}
@@ -436,6 +543,7 @@
 //		"fmt"
 //		"time"
 //
+//		"github.com/cznic/99c/exe"
 //		"github.com/cznic/httpfs"
 //		"github.com/cznic/ir"
 //		"github.com/cznic/virtual"
@@ -449,12 +557,12 @@
 //			panic(err)
 //		}
 //
-//		var bin virtual.Binary
-//		if _, err := bin.ReadFrom(f); err != nil {
+//		_, bin, err := exe.Read(f)
+//		if err != nil {
 //			panic(err)
 //		}
 //
-//		m, _, err := virtual.New(&bin, nil, nil, nil, nil, 0, 1<<10, "")
+//		m, _, err := virtual.New(bin, nil, nil, nil, nil, 0, 1<<10, "")
 //		if err != nil {
 //			panic(err)
 //		}
@@ -518,6 +626,7 @@
 //		"fmt"
 //		"os"
 //
+//		"github.com/cznic/99c/exe"
 //		"github.com/cznic/ir"
 //		"github.com/cznic/virtual"
 //		"github.com/cznic/xc"
@@ -529,12 +638,12 @@
 //			panic(err)
 //		}
 //
-//		var bin virtual.Binary
-//		if _, err := bin.ReadFrom(f); err != nil {
+//		_, bin, err := exe.Read(f)
+//		if err != nil {
 //			panic(err)
 //		}
 //
-//		m, _, err := virtual.New(&bin, nil, nil, nil, nil, 0, 1<<10, "")
+//		m, _, err := virtual.New(bin, nil, nil, nil, nil, 0, 1<<10, "")
 //		if err != nil {
 //			panic(err)
 //		}
@@ -619,6 +728,31 @@
 //	hello
 //	$
 //
+// Configuration file
+//
+// Default -99extra, -B, -D, -I, -L, -l, -fno- and -std flags can be set in a
+// configuration file named .99c.toml. The file is searched for in the directory of the first
+// C source file and its parent directories. The NINETYNINEC_CONFIG environment
+// variable, if set, names the configuration file to use instead. Flags given on
+// the command line take precedence. Use -99noconfig to ignore the configuration
+// file and -99showconfig to print the effective configuration. The output
+// of -99showconfig lists every key and is itself a valid configuration file.
+//
+// The file uses a subset of TOML. Relative paths are relative to the directory
+// of the configuration file. The noextra key lists the extensions disabled as
+// by -fno-, the prefix key the 99c package prefixes added as by -B. An empty
+// std selects the default dialect.
+//
+//	# .99c.toml
+//	extra = ["ImplicitFuncDef", "ImplicitIntType", "Asm"]
+//	define = ["_GNU_SOURCE", "VERSION=\"1.0\""]
+//	include = ["include"]
+//	libdir = ["lib"]
+//	lib = ["xcb"]
+//	noextra = ["Asm"]
+//	prefix = ["/opt/99c"]
+//	std = "gnu99"
+//
 // Installing C packages
 //
 // To use a C package with programs compiled by 99c it's necessary to install a
@@ -635,6 +769,12 @@
 // will install the 99c version of libxcb on your system in '$HOME/.99c'.
 // Currently supported only on Linux.
 //
+// The compiler searches the include and lib directories of the package
+// prefixes. Prefixes are set using the -B and --prefix flags and the
+// NINETYNINEC_ROOT environment variable, which holds a list of prefixes
+// separated by the OS specific path list separator. If no prefix is set,
+// '$HOME/.99c' is used.
+//
 // Talking to X server
 //
 // A bare bones example, currently supported only on Linux.
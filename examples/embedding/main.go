@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/cznic/99c/exe"
 	"github.com/cznic/httpfs"
 	"github.com/cznic/virtual"
 )
@@ -20,13 +21,13 @@ func main() {
 		panic(err)
 	}
 
-	var bin virtual.Binary
-	if _, err := bin.ReadFrom(f); err != nil {
+	_, bin, err := exe.Read(f)
+	if err != nil {
 		panic(err)
 	}
 
 	var out bytes.Buffer
-	exitCode, err := virtual.Exec(&bin, nil, strings.NewReader("Foo Bar"), &out, &out, 0, 1<<20, "")
+	exitCode, err := virtual.Exec(bin, nil, strings.NewReader("Foo Bar"), &out, &out, 0, 1<<20, "")
 	if err != nil {
 		panic(err)
 	}
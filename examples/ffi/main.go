@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/cznic/99c/exe"
 	"github.com/cznic/httpfs"
 	"github.com/cznic/ir"
 	"github.com/cznic/virtual"
@@ -20,12 +21,12 @@ func main() {
 		panic(err)
 	}
 
-	var bin virtual.Binary
-	if _, err := bin.ReadFrom(f); err != nil {
+	_, bin, err := exe.Read(f)
+	if err != nil {
 		panic(err)
 	}
 
-	m, _, err := virtual.New(&bin, nil, nil, nil, nil, 0, 1<<10, "")
+	m, _, err := virtual.New(bin, nil, nil, nil, nil, 0, 1<<10, "")
 	if err != nil {
 		panic(err)
 	}
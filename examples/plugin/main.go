@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/cznic/99c/exe"
 	"github.com/cznic/ir"
 	"github.com/cznic/virtual"
 	"github.com/cznic/xc"
@@ -17,12 +18,12 @@ func main() {
 		panic(err)
 	}
 
-	var bin virtual.Binary
-	if _, err := bin.ReadFrom(f); err != nil {
+	_, bin, err := exe.Read(f)
+	if err != nil {
 		panic(err)
 	}
 
-	m, _, err := virtual.New(&bin, nil, nil, nil, nil, 0, 1<<10, "")
+	m, _, err := virtual.New(bin, nil, nil, nil, nil, 0, 1<<10, "")
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,136 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exe
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/cznic/99c/debuginfo"
+)
+
+func caller(s string, va ...interface{}) {
+	if s == "" {
+		s = strings.Repeat("%v ", len(va))
+	}
+	_, fn, fl, _ := runtime.Caller(2)
+	fmt.Fprintf(os.Stderr, "# caller: %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintf(os.Stderr, s, va...)
+	fmt.Fprintln(os.Stderr)
+	_, fn, fl, _ = runtime.Caller(1)
+	fmt.Fprintf(os.Stderr, "# \tcallee: %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintln(os.Stderr)
+	os.Stderr.Sync()
+}
+
+func dbg(s string, va ...interface{}) {
+	if s == "" {
+		s = strings.Repeat("%v ", len(va))
+	}
+	_, fn, fl, _ := runtime.Caller(1)
+	fmt.Fprintf(os.Stderr, "# dbg %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintf(os.Stderr, s, va...)
+	fmt.Fprintln(os.Stderr)
+	os.Stderr.Sync()
+}
+
+func TODO(...interface{}) string { //TODOOK
+	_, fn, fl, _ := runtime.Caller(1)
+	return fmt.Sprintf("# TODO: %s:%d:\n", path.Base(fn), fl) //TODOOK
+}
+
+func use(...interface{}) {}
+
+func init() {
+	use(caller, dbg, TODO) //TODOOK
+}
+
+// ============================================================================
+
+func TestHeader(t *testing.T) {
+	for _, shebang := range []string{"", "#!/usr/bin/env 99run\n"} {
+		var buf bytes.Buffer
+		buf.WriteString(shebang)
+		if _, err := (&Header{Target: "linux/386"}).WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+
+		buf.WriteString("payload")
+		r := bufio.NewReader(&buf)
+		h, err := readHeader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if g, e := h.Target, "linux/386"; g != e {
+			t.Fatalf("got %q, expected %q", g, e)
+		}
+
+		if rest, _ := ioutil.ReadAll(r); string(rest) != "payload" {
+			t.Fatalf("unexpected payload %q", rest)
+		}
+	}
+}
+
+func TestDebugHeader(t *testing.T) {
+	info := &debuginfo.Info{
+		Types:   []debuginfo.Type{{Kind: debuginfo.Int, Name: "int", Size: 4, Align: 4}},
+		Globals: []debuginfo.Variable{{Name: "i", Offset: 0x1000}},
+	}
+	var buf bytes.Buffer
+	if _, err := (&Header{Debug: info}).WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := readHeader(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(h.Debug, info) {
+		t.Fatalf("got %+v, expected %+v", h.Debug, info)
+	}
+}
+
+func TestInvalidDebugHeader(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := (&Header{Debug: &debuginfo.Info{Types: []debuginfo.Type{{Kind: debuginfo.Pointer, Size: 8, Elem: 1}}}}).WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readHeader(bufio.NewReader(&buf)); err == nil || !strings.Contains(err.Error(), "invalid debug information") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestLegacyHeader(t *testing.T) {
+	for _, s := range []string{"payload", "#!/usr/bin/env 99run\npayload"} {
+		r := bufio.NewReader(strings.NewReader(s))
+		h, err := readHeader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if h.Target != "" {
+			t.Fatalf("unexpected target %q", h.Target)
+		}
+
+		if err := h.CheckTarget(); err != nil {
+			t.Fatal(err)
+		}
+
+		if rest, _ := ioutil.ReadAll(r); string(rest) != "payload" {
+			t.Fatalf("unexpected payload %q", rest)
+		}
+	}
+}
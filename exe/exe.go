@@ -0,0 +1,181 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package exe handles the file format of executables produced by the 99c
+// compiler.
+//
+// An executable consists of an optional shebang line, a header and a
+// serialized virtual.Binary. The header starts with Magic followed by a
+// version byte, the uvarint encoded length of the header data and the header
+// data itself. Executables produced before the header was introduced are
+// still accepted by Read and they report a zero Header.
+package exe
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+
+	"github.com/cznic/99c/debuginfo"
+	"github.com/cznic/virtual"
+	"github.com/cznic/xc"
+)
+
+const (
+	// Magic starts the header of an executable.
+	Magic = "\x7f99c"
+
+	// Version is the header format version written by this package.
+	Version = 1
+
+	maxHeader = 1 << 28
+)
+
+// Header describes an executable.
+type Header struct {
+	// BuildID is a hash of the content of the executable. See BuildID.
+	BuildID string `json:",omitempty"`
+
+	// Debug describes the variables and types of the program, if known. 99c
+	// does not produce it.
+	Debug *debuginfo.Info `json:",omitempty"`
+
+	// Target is the GOOS/GOARCH the executable was compiled for. Empty for
+	// executables produced before the header was introduced.
+	Target string `json:",omitempty"`
+}
+
+// BuildID returns a build ID computed from the content of b. Equal binaries
+// have equal build IDs.
+func BuildID(b *virtual.Binary) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%v\n%x\n%x\n%x\n%x\n%v\n", b.Code, b.Text, b.Data, b.TSRelative, b.DSRelative, b.BSS)
+	fmt.Fprintf(h, "%v\n%v\n", b.Functions, b.Lines)
+	var a []string
+	for k, v := range b.Sym {
+		a = append(a, fmt.Sprintf("%s=%v", xc.Dict.S(int(k)), v))
+	}
+	sort.Strings(a)
+	fmt.Fprintf(h, "%q\n", a)
+	return hex.EncodeToString(h.Sum(nil)[:20])
+}
+
+// HostTarget returns the GOOS/GOARCH of the running program.
+func HostTarget() string { return runtime.GOOS + "/" + runtime.GOARCH }
+
+// CheckTarget returns an error if the executable described by h cannot be run
+// on the host.
+func (h *Header) CheckTarget() error {
+	if h.Target == "" || h.Target == HostTarget() {
+		return nil
+	}
+
+	return fmt.Errorf("executable compiled for %s cannot be run on %s", h.Target, HostTarget())
+}
+
+// WriteTo writes h to w.
+func (h *Header) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return 0, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(Magic)
+	buf.WriteByte(Version)
+	var a [binary.MaxVarintLen64]byte
+	buf.Write(a[:binary.PutUvarint(a[:], uint64(len(data)))])
+	buf.Write(data)
+	return buf.WriteTo(w)
+}
+
+// Write writes an executable consisting of h and b to w.
+func Write(w io.Writer, h *Header, b *virtual.Binary) error {
+	if _, err := h.WriteTo(w); err != nil {
+		return err
+	}
+
+	_, err := b.WriteTo(w)
+	return err
+}
+
+// Read reads an executable from r.
+func Read(r io.Reader) (*Header, *virtual.Binary, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	h, err := readHeader(br)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var b virtual.Binary
+	if _, err := b.ReadFrom(br); err != nil {
+		return nil, nil, err
+	}
+
+	return h, &b, nil
+}
+
+func readHeader(r *bufio.Reader) (*Header, error) {
+	b, err := r.Peek(2)
+	if err == nil && string(b) == "#!" {
+		if _, err := r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+	}
+
+	var h Header
+	if b, err = r.Peek(len(Magic)); err != nil || string(b) != Magic {
+		return &h, nil
+	}
+
+	if _, err := r.Discard(len(Magic)); err != nil {
+		return nil, err
+	}
+
+	v, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	if v > Version {
+		return nil, fmt.Errorf("unsupported executable format version %v", v)
+	}
+
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if n > maxHeader {
+		return nil, fmt.Errorf("invalid executable header size %v", n)
+	}
+
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, fmt.Errorf("invalid executable header: %v", err)
+	}
+
+	if h.Debug != nil {
+		if err := h.Debug.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid debug information: %v", err)
+		}
+	}
+
+	return &h, nil
+}
@@ -0,0 +1,272 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gdbserver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/cznic/99c/debugger"
+)
+
+func caller(s string, va ...interface{}) {
+	if s == "" {
+		s = strings.Repeat("%v ", len(va))
+	}
+	_, fn, fl, _ := runtime.Caller(2)
+	fmt.Fprintf(os.Stderr, "# caller: %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintf(os.Stderr, s, va...)
+	fmt.Fprintln(os.Stderr)
+	_, fn, fl, _ = runtime.Caller(1)
+	fmt.Fprintf(os.Stderr, "# \tcallee: %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintln(os.Stderr)
+	os.Stderr.Sync()
+}
+
+func dbg(s string, va ...interface{}) {
+	if s == "" {
+		s = strings.Repeat("%v ", len(va))
+	}
+	_, fn, fl, _ := runtime.Caller(1)
+	fmt.Fprintf(os.Stderr, "# dbg %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintf(os.Stderr, s, va...)
+	fmt.Fprintln(os.Stderr)
+	os.Stderr.Sync()
+}
+
+func TODO(...interface{}) string { //TODOOK
+	_, fn, fl, _ := runtime.Caller(1)
+	return fmt.Sprintf("# TODO: %s:%d:\n", path.Base(fn), fl) //TODOOK
+}
+
+func use(...interface{}) {}
+
+func init() {
+	use(caller, dbg, TODO) //TODOOK
+}
+
+// ============================================================================
+
+type testMachine struct {
+	exitPC uint64
+	loopPC uint64 // Step does not advance the PC at loopPC.
+	mem    []byte
+	pc     uint64
+}
+
+func newTestMachine() *testMachine {
+	m := &testMachine{exitPC: 0x20, loopPC: 1 << 63, mem: make([]byte, 16)}
+	m.mem[0] = 42
+	return m
+}
+
+func (m *testMachine) Depth() int { return 2 }
+
+func (m *testMachine) Frames() []debugger.Frame {
+	return []debugger.Frame{{AP: 0x2000, BP: 0x2100, PC: m.pc}, {PC: 0x99}}
+}
+
+func (m *testMachine) ReadMemory(addr uint64, n int) ([]byte, error) {
+	if addr < 0x1000 || addr+uint64(n) > 0x1000+uint64(len(m.mem)) {
+		return nil, fmt.Errorf("invalid address %#x", addr)
+	}
+
+	return m.mem[addr-0x1000 : addr-0x1000+uint64(n)], nil
+}
+
+func (m *testMachine) PC() uint64 { return m.pc }
+
+func (m *testMachine) SP() uint64 { return 0x3000 }
+
+func (m *testMachine) Step() (bool, int, error) {
+	switch m.pc {
+	case m.exitPC:
+		return true, 42, nil
+	case m.loopPC:
+		return false, 0, nil
+	}
+
+	m.pc++
+	return false, 0, nil
+}
+
+func (m *testMachine) WriteMemory(addr uint64, b []byte) error {
+	if addr < 0x1000 || addr+uint64(len(b)) > 0x1000+uint64(len(m.mem)) {
+		return fmt.Errorf("invalid address %#x", addr)
+	}
+
+	copy(m.mem[addr-0x1000:], b)
+	return nil
+}
+
+// testClient is a scripted GDB.
+type testClient struct {
+	conn  net.Conn
+	noAck bool
+	r     *bufio.Reader
+	t     *testing.T
+}
+
+type serveResult struct {
+	exited bool
+	code   int
+	err    error
+}
+
+func newTestClient(t *testing.T, m debugger.Machine) (*testClient, chan serveResult) {
+	c, s := net.Pipe()
+	ch := make(chan serveResult, 1)
+	go func() {
+		exited, code, err := Serve(s, m)
+		s.Close()
+		ch <- serveResult{exited, code, err}
+	}()
+	return &testClient{conn: c, r: bufio.NewReader(c), t: t}, ch
+}
+
+func (c *testClient) expect(b byte) {
+	got, err := c.r.ReadByte()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	if got != b {
+		c.t.Fatalf("got %q, expected %q", got, b)
+	}
+}
+
+// write sends packet, which must be escaped already.
+func (c *testClient) write(packet string) {
+	if _, err := fmt.Fprintf(c.conn, "$%s#%02x", packet, checksum([]byte(packet))); err != nil {
+		c.t.Fatal(err)
+	}
+
+	if !c.noAck {
+		c.expect('+')
+	}
+}
+
+func (c *testClient) reply() string {
+	c.expect('$')
+	data, err := c.r.ReadBytes('#')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	data = data[:len(data)-1]
+	var sum [2]byte
+	if _, err := io.ReadFull(c.r, sum[:]); err != nil {
+		c.t.Fatal(err)
+	}
+
+	if g, e := string(sum[:]), fmt.Sprintf("%02x", checksum(data)); g != e {
+		c.t.Fatalf("invalid checksum %s, expected %s", g, e)
+	}
+
+	if !c.noAck {
+		// The server may have closed the connection after replying.
+		c.conn.Write([]byte{'+'})
+	}
+	return string(unescape(data))
+}
+
+func (c *testClient) cmd(packet, reply string) {
+	c.write(packet)
+	if g, e := c.reply(), reply; g != e {
+		c.t.Fatalf("%s: got %q, expected %q", packet, g, e)
+	}
+}
+
+func TestServe(t *testing.T) {
+	m := newTestMachine()
+	c, ch := newTestClient(t, m)
+	c.write("qSupported:multiprocess+;swbreak+")
+	if s := c.reply(); !strings.Contains(s, "qXfer:features:read+") {
+		t.Fatalf("unexpected %q", s)
+	}
+
+	c.cmd("?", "S05")
+	c.cmd("qXfer:features:read:target.xml:0,10", "m<?xml version=\"1")
+	c.cmd("qXfer:features:read:target.xml:100,1000", "l"+TargetDescription[0x100:])
+	c.cmd("g", "0000000000000000"+"0030000000000000"+"0021000000000000"+"0020000000000000")
+	c.cmd("Z0,10,1", "OK")
+	c.cmd("c", "T05swbreak:;")
+	c.cmd("p0", "1000000000000000")
+	c.cmd("p3", "0020000000000000")
+	c.cmd("p9", "E01")
+	c.cmd("m1000,4", "2a000000")
+	c.cmd("M1000,2:ffff", "OK")
+	c.cmd("m1000,2", "ffff")
+	c.cmd("X1002,2:a}]", "OK")
+	c.cmd("m1002,2", "617d")
+	c.cmd("mffff0,4", "E01")
+	c.cmd("z0,10,1", "OK")
+	c.cmd("s", "S05")
+	c.cmd("p0", "1100000000000000")
+	c.cmd("vMustReplyEmpty", "")
+
+	// A corrupted packet is rejected.
+	if _, err := io.WriteString(c.conn, "$g#00"); err != nil {
+		t.Fatal(err)
+	}
+
+	c.expect('-')
+	c.cmd("QStartNoAckMode", "OK")
+	c.noAck = true
+	c.cmd("vCont?", "vCont;c;C;s;S")
+	c.cmd("vCont;", "E01")
+	c.cmd("vCont;x", "E01")
+	c.cmd("vCont;C", "E01")
+	c.cmd("vCont;C0b", "E01")
+	c.cmd("vCont;S0b:1", "E01")
+	c.cmd("C0b", "E01")
+	c.cmd("S0b;10", "E01")
+	c.cmd("vCont;s:1", "S05")
+	c.cmd("vCont;c", "W2a")
+	if r := <-ch; !r.exited || r.code != 42 || r.err != nil {
+		t.Fatalf("%+v", r)
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	m := newTestMachine()
+	m.loopPC = 3
+	c, ch := newTestClient(t, m)
+	c.write("c")
+	if _, err := c.conn.Write([]byte{0x03}); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := c.reply(), "S02"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	c.cmd("?", "S02")
+	c.cmd("D", "OK")
+	if r := <-ch; r.exited || r.err != nil {
+		t.Fatalf("%+v", r)
+	}
+}
+
+func TestKill(t *testing.T) {
+	c, ch := newTestClient(t, newTestMachine())
+	c.write("k")
+	if r := <-ch; r.exited || r.err != ErrKilled {
+		t.Fatalf("%+v", r)
+	}
+
+	c, ch = newTestClient(t, newTestMachine())
+	c.conn.Close()
+	if r := <-ch; r.exited || r.err != nil {
+		t.Fatalf("%+v", r)
+	}
+}
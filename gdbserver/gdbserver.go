@@ -0,0 +1,538 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package gdbserver implements the GDB remote serial protocol for programs
+// executed by the virtual machine.
+//
+// The server exposes a single thread with the 64 bit registers pc, sp, bp and
+// ap, in this order, described by TargetDescription. It supports reading the
+// registers, reading and writing memory, software and hardware breakpoints,
+// single stepping, continuing, interrupting a running program with Ctrl-C,
+// detaching and killing the program. The virtual machine provides no way to
+// deliver a signal to the program, continuing or stepping with a signal fails.
+package gdbserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/cznic/99c/debugger"
+)
+
+// TargetDescription describes the registers of the virtual machine. It is
+// sent to GDB as target.xml.
+const TargetDescription = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.cznic.99c.vm">
+    <reg name="pc" bitsize="64" type="code_ptr" regnum="0"/>
+    <reg name="sp" bitsize="64" type="data_ptr"/>
+    <reg name="bp" bitsize="64" type="data_ptr"/>
+    <reg name="ap" bitsize="64" type="data_ptr"/>
+  </feature>
+</target>
+`
+
+const (
+	interrupt = 0x03
+	sigint    = 2
+	sigtrap   = 5
+	sigsegv   = 11
+)
+
+// ErrKilled is returned by Serve when GDB kills the program.
+var ErrKilled = errors.New("killed by the debugger")
+
+// MemoryWriter is implemented by machines supporting writes to the memory of
+// the program.
+type MemoryWriter interface {
+	WriteMemory(addr uint64, b []byte) error
+}
+
+// event is a packet, an interrupt or a negative acknowledgement received from
+// GDB or an error reading the connection.
+type event struct {
+	bad       bool // Packet with an invalid checksum.
+	err       error
+	interrupt bool
+	nack      bool
+	packet    string
+}
+
+type server struct {
+	breakpoints map[uint64]struct{}
+	done        chan struct{}
+	events      chan event
+	last        string // The last packet sent, for retransmission.
+	m           debugger.Machine
+	noAck       bool
+	pending     []event
+	stop        string // The last stop reply.
+	w           io.Writer
+}
+
+var (
+	errClosed = errors.New("connection closed")
+	errDetach = errors.New("detached")
+)
+
+// noReply is returned by handle for packets not to be replied to.
+const noReply = "\x00"
+
+// Serve serves GDB connected to conn, controlling m. Serve returns when the
+// program exits, when GDB detaches or closes the connection, in which case the
+// program may continue to run, or when GDB kills the program, in which case
+// the error is ErrKilled. If the program exited, Serve returns true and its
+// exit code.
+func Serve(conn io.ReadWriter, m debugger.Machine) (exited bool, code int, err error) {
+	s := &server{
+		breakpoints: map[uint64]struct{}{},
+		done:        make(chan struct{}),
+		events:      make(chan event),
+		m:           m,
+		stop:        fmt.Sprintf("S%02x", sigtrap),
+		w:           conn,
+	}
+
+	defer close(s.done)
+
+	go s.read(bufio.NewReader(conn))
+	for {
+		ev, err := s.next()
+		switch {
+		case err == errClosed:
+			return false, 0, nil
+		case err != nil:
+			return false, 0, err
+		}
+
+		switch {
+		case ev.nack:
+			if err := s.write(s.last); err != nil {
+				return false, 0, err
+			}
+
+			continue
+		case ev.bad, ev.interrupt:
+			continue
+		}
+
+		reply, exited, code, err := s.handle(ev.packet)
+		if reply != noReply {
+			if e := s.send(reply); e != nil && err == nil {
+				err = e
+			}
+		}
+		switch {
+		case exited:
+			return true, code, nil
+		case err == errClosed, err == errDetach:
+			return false, 0, nil
+		case err != nil:
+			return false, 0, err
+		}
+
+		if ev.packet == "QStartNoAckMode" {
+			s.noAck = true
+		}
+	}
+}
+
+// read parses the data received from GDB into events.
+func (s *server) read(r *bufio.Reader) {
+	for {
+		ev := readEvent(r)
+		select {
+		case s.events <- ev:
+		case <-s.done:
+			return
+		}
+
+		if ev.err != nil {
+			return
+		}
+	}
+}
+
+func readEvent(r *bufio.Reader) event {
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return event{err: err}
+		}
+
+		switch c {
+		case interrupt:
+			return event{interrupt: true}
+		case '-':
+			return event{nack: true}
+		case '$':
+			data, err := r.ReadBytes('#')
+			if err != nil {
+				return event{err: err}
+			}
+
+			data = data[:len(data)-1]
+			var sum [2]byte
+			if _, err := io.ReadFull(r, sum[:]); err != nil {
+				return event{err: err}
+			}
+
+			if n, err := strconv.ParseUint(string(sum[:]), 16, 8); err != nil || byte(n) != checksum(data) {
+				return event{bad: true}
+			}
+
+			return event{packet: string(unescape(data))}
+		}
+		// Acknowledgements and noise are ignored.
+	}
+}
+
+// receive acknowledges a packet received from GDB. A closed connection is
+// reported as errClosed.
+func (s *server) receive(ev event) (event, error) {
+	switch {
+	case ev.err == io.EOF:
+		return ev, errClosed
+	case ev.err != nil:
+		return ev, ev.err
+	case s.noAck, ev.interrupt, ev.nack:
+		return ev, nil
+	case ev.bad:
+		return ev, s.write("-")
+	}
+	return ev, s.write("+")
+}
+
+// next returns the next event, either postponed while the program was running
+// or received from GDB.
+func (s *server) next() (event, error) {
+	if len(s.pending) != 0 {
+		ev := s.pending[0]
+		s.pending = s.pending[1:]
+		return ev, nil
+	}
+
+	return s.receive(<-s.events)
+}
+
+func checksum(b []byte) byte {
+	var n byte
+	for _, c := range b {
+		n += c
+	}
+	return n
+}
+
+// unescape decodes the binary data of a packet.
+func unescape(b []byte) []byte {
+	var r []byte
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		if c == '}' && i+1 < len(b) {
+			i++
+			c = b[i] ^ 0x20
+		}
+		r = append(r, c)
+	}
+	return r
+}
+
+// send sends the packet data.
+func (s *server) send(data string) error {
+	var buf bytes.Buffer
+	buf.WriteByte('$')
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; c {
+		case '$', '#', '}', '*':
+			buf.WriteByte('}')
+			buf.WriteByte(c ^ 0x20)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	body := buf.Bytes()[1:]
+	fmt.Fprintf(&buf, "#%02x", checksum(body))
+	s.last = buf.String()
+	return s.write(s.last)
+}
+
+func (s *server) write(packet string) error {
+	_, err := io.WriteString(s.w, packet)
+	return err
+}
+
+// handle executes the command in packet and returns the reply.
+func (s *server) handle(packet string) (reply string, exited bool, code int, err error) {
+	switch {
+	case packet == "?":
+		return s.stop, false, 0, nil
+	case strings.HasPrefix(packet, "c"):
+		return s.resume(false)
+	case strings.HasPrefix(packet, "C"):
+		return s.resumeSignal(packet[1:], false)
+	case packet == "D" || strings.HasPrefix(packet, "D;"):
+		return "OK", false, 0, errDetach
+	case packet == "g":
+		return s.registers(), false, 0, nil
+	case strings.HasPrefix(packet, "H"), strings.HasPrefix(packet, "T"):
+		return "OK", false, 0, nil
+	case packet == "k":
+		return noReply, false, 0, ErrKilled
+	case strings.HasPrefix(packet, "m"):
+		return s.readMemory(packet[1:]), false, 0, nil
+	case strings.HasPrefix(packet, "M"):
+		return s.writeMemory(packet[1:], true), false, 0, nil
+	case strings.HasPrefix(packet, "p"):
+		return s.register(packet[1:]), false, 0, nil
+	case packet == "qAttached" || strings.HasPrefix(packet, "qAttached:"):
+		return "1", false, 0, nil
+	case packet == "qC":
+		return "QC1", false, 0, nil
+	case packet == "qfThreadInfo":
+		return "m1", false, 0, nil
+	case packet == "qsThreadInfo":
+		return "l", false, 0, nil
+	case strings.HasPrefix(packet, "qSupported"):
+		return "PacketSize=4000;qXfer:features:read+;QStartNoAckMode+;swbreak+;hwbreak+;vContSupported+", false, 0, nil
+	case packet == "qSymbol::":
+		return "OK", false, 0, nil
+	case strings.HasPrefix(packet, "qXfer:features:read:target.xml:"):
+		return s.targetXML(packet[len("qXfer:features:read:target.xml:"):]), false, 0, nil
+	case packet == "QStartNoAckMode":
+		// Serve stops acknowledging after replying.
+		return "OK", false, 0, nil
+	case strings.HasPrefix(packet, "s"):
+		return s.resume(true)
+	case strings.HasPrefix(packet, "S"):
+		return s.resumeSignal(packet[1:], true)
+	case packet == "vCont?":
+		return "vCont;c;C;s;S", false, 0, nil
+	case strings.HasPrefix(packet, "vCont;"):
+		return s.vCont(packet[len("vCont;"):])
+	case strings.HasPrefix(packet, "vKill"):
+		return "OK", false, 0, ErrKilled
+	case strings.HasPrefix(packet, "X"):
+		return s.writeMemory(packet[1:], false), false, 0, nil
+	case strings.HasPrefix(packet, "Z0,"), strings.HasPrefix(packet, "Z1,"):
+		return s.breakpoint(packet[3:], true), false, 0, nil
+	case strings.HasPrefix(packet, "z0,"), strings.HasPrefix(packet, "z1,"):
+		return s.breakpoint(packet[3:], false), false, 0, nil
+	}
+	return "", false, 0, nil
+}
+
+// vCont performs the first action of the vCont action list a. The program
+// has a single thread, the threads the actions apply to are ignored.
+func (s *server) vCont(a string) (reply string, exited bool, code int, err error) {
+	if i := strings.IndexAny(a, ";:"); i >= 0 {
+		a = a[:i]
+	}
+	switch {
+	case a == "c":
+		return s.resume(false)
+	case a == "s":
+		return s.resume(true)
+	case len(a) == 3 && (a[0] == 'C' || a[0] == 'S'):
+		return s.resumeSignal(a[1:], a[0] == 'S')
+	}
+	return "E01", false, 0, nil
+}
+
+// resumeSignal resumes the program like resume with the signal in arg, which
+// may be followed by ;ADDR. Signals cannot be delivered, only signal 0, ie. no
+// signal, is accepted.
+func (s *server) resumeSignal(arg string, step bool) (reply string, exited bool, code int, err error) {
+	if i := strings.IndexByte(arg, ';'); i >= 0 {
+		arg = arg[:i]
+	}
+	if n, err := strconv.ParseUint(arg, 16, 8); err != nil || n != 0 {
+		return "E01", false, 0, nil
+	}
+
+	return s.resume(step)
+}
+
+// resume executes a single instruction if step is true, otherwise it runs
+// the program until a breakpoint is hit, GDB interrupts the program or the
+// program terminates.
+func (s *server) resume(step bool) (reply string, exited bool, code int, err error) {
+	for {
+		exited, code, err := s.m.Step()
+		if err != nil {
+			return fmt.Sprintf("X%02x", sigsegv), false, 0, err
+		}
+
+		if exited {
+			return fmt.Sprintf("W%02x", code&0xff), true, code, nil
+		}
+
+		if _, ok := s.breakpoints[s.m.PC()]; ok {
+			s.stop = fmt.Sprintf("T%02xswbreak:;", sigtrap)
+			return s.stop, false, 0, nil
+		}
+
+		if step {
+			s.stop = fmt.Sprintf("S%02x", sigtrap)
+			return s.stop, false, 0, nil
+		}
+
+		select {
+		case ev := <-s.events:
+			ev, err := s.receive(ev)
+			if err != nil {
+				return noReply, false, 0, err
+			}
+
+			if ev.interrupt {
+				s.stop = fmt.Sprintf("S%02x", sigint)
+				return s.stop, false, 0, nil
+			}
+
+			s.pending = append(s.pending, ev)
+		default:
+		}
+	}
+}
+
+func (s *server) frame() debugger.Frame {
+	if f := s.m.Frames(); len(f) != 0 {
+		return f[0]
+	}
+
+	return debugger.Frame{}
+}
+
+func (s *server) registerValues() []uint64 {
+	f := s.frame()
+	return []uint64{s.m.PC(), s.m.SP(), f.BP, f.AP}
+}
+
+func hex64(n uint64) string {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], n)
+	return hex.EncodeToString(b[:])
+}
+
+func (s *server) registers() string {
+	var r string
+	for _, v := range s.registerValues() {
+		r += hex64(v)
+	}
+	return r
+}
+
+func (s *server) register(arg string) string {
+	n, err := strconv.ParseUint(arg, 16, 32)
+	regs := s.registerValues()
+	if err != nil || n >= uint64(len(regs)) {
+		return "E01"
+	}
+
+	return hex64(regs[n])
+}
+
+// addrLen parses ADDR,LEN.
+func addrLen(s string) (uint64, int, error) {
+	a := strings.SplitN(s, ",", 2)
+	if len(a) != 2 {
+		return 0, 0, fmt.Errorf("invalid address and length %q", s)
+	}
+
+	addr, err := strconv.ParseUint(a[0], 16, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	n, err := strconv.ParseUint(a[1], 16, 31)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return addr, int(n), nil
+}
+
+func (s *server) readMemory(arg string) string {
+	addr, n, err := addrLen(arg)
+	if err != nil {
+		return "E01"
+	}
+
+	b, err := s.m.ReadMemory(addr, n)
+	if err != nil {
+		return "E01"
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// writeMemory handles the M, if hexData is true, and X packets.
+func (s *server) writeMemory(arg string, hexData bool) string {
+	w, ok := s.m.(MemoryWriter)
+	i := strings.IndexByte(arg, ':')
+	if !ok || i < 0 {
+		return "E01"
+	}
+
+	addr, n, err := addrLen(arg[:i])
+	if err != nil {
+		return "E01"
+	}
+
+	b := []byte(arg[i+1:])
+	if hexData {
+		if b, err = hex.DecodeString(arg[i+1:]); err != nil {
+			return "E01"
+		}
+	}
+	if len(b) != n {
+		return "E01"
+	}
+
+	if n != 0 {
+		if err := w.WriteMemory(addr, b); err != nil {
+			return "E01"
+		}
+	}
+	return "OK"
+}
+
+// breakpoint handles the Z0, Z1, z0 and z1 packets.
+func (s *server) breakpoint(arg string, insert bool) string {
+	a := strings.SplitN(arg, ",", 2)
+	addr, err := strconv.ParseUint(a[0], 16, 64)
+	if err != nil {
+		return "E01"
+	}
+
+	if insert {
+		s.breakpoints[addr] = struct{}{}
+		return "OK"
+	}
+
+	delete(s.breakpoints, addr)
+	return "OK"
+}
+
+// targetXML handles reading OFFSET,LENGTH of TargetDescription.
+func (s *server) targetXML(arg string) string {
+	off, n, err := addrLen(arg)
+	if err != nil || off > uint64(len(TargetDescription)) {
+		return "E01"
+	}
+
+	r := TargetDescription[off:]
+	if len(r) > n {
+		return "m" + r[:n]
+	}
+
+	return "l" + r
+}
@@ -0,0 +1,281 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package host
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func caller(s string, va ...interface{}) {
+	if s == "" {
+		s = strings.Repeat("%v ", len(va))
+	}
+	_, fn, fl, _ := runtime.Caller(2)
+	fmt.Fprintf(os.Stderr, "# caller: %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintf(os.Stderr, s, va...)
+	fmt.Fprintln(os.Stderr)
+	_, fn, fl, _ = runtime.Caller(1)
+	fmt.Fprintf(os.Stderr, "# \tcallee: %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintln(os.Stderr)
+	os.Stderr.Sync()
+}
+
+func dbg(s string, va ...interface{}) {
+	if s == "" {
+		s = strings.Repeat("%v ", len(va))
+	}
+	_, fn, fl, _ := runtime.Caller(1)
+	fmt.Fprintf(os.Stderr, "# dbg %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintf(os.Stderr, s, va...)
+	fmt.Fprintln(os.Stderr)
+	os.Stderr.Sync()
+}
+
+func TODO(...interface{}) string { //TODOOK
+	_, fn, fl, _ := runtime.Caller(1)
+	return fmt.Sprintf("# TODO: %s:%d:\n", path.Base(fn), fl) //TODOOK
+}
+
+func use(...interface{}) {}
+
+func init() {
+	use(caller, dbg, TODO) //TODOOK
+}
+
+// ============================================================================
+
+func TestPolicy(t *testing.T) {
+	var log bytes.Buffer
+	tmp, err := ioutil.TempDir("", "99c-host-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tmp)
+
+	if tmp, err = filepath.EvalSymlinks(tmp); err != nil {
+		t.Fatal(err)
+	}
+
+	wd := filepath.Join(tmp, "wd")
+	if err := os.MkdirAll(filepath.Join(wd, "out"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Policy{
+		Deny:       []string{"process", "getenv"},
+		Dir:        wd,
+		Log:        &log,
+		WritePaths: []string{"out"},
+	}
+	if runtime.GOOS != "windows" {
+		if err := os.Symlink(tmp, filepath.Join(wd, "out", "escape")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, v := range []struct {
+		name string
+		args []interface{}
+		err  error
+	}{
+		{"system", []interface{}{"rm -rf /"}, syscall.EPERM},
+		{"getenv", []interface{}{"HOME"}, syscall.EPERM},
+		{"setenv", []interface{}{"HOME", "/", 1}, nil},
+		{"open", []interface{}{"data.txt", syscall.O_RDONLY, 0}, nil},
+		{"open", []interface{}{"data.txt", syscall.O_RDWR, 0}, syscall.EACCES},
+		{"open", []interface{}{"out/data.txt", syscall.O_WRONLY | syscall.O_CREAT, 0644}, nil},
+		{"open", []interface{}{filepath.Join(wd, "out", "data.txt"), syscall.O_WRONLY, 0}, nil},
+		{"open", []interface{}{"out/../data.txt", syscall.O_WRONLY, 0}, syscall.EACCES},
+		{"rename", []interface{}{"out/a", "b"}, syscall.EACCES},
+		{"unlink", []interface{}{"out/a"}, nil},
+		{"chmod", []interface{}{"data.txt", 0777}, syscall.EACCES},
+		{"chmod", []interface{}{"out/a", 0777}, nil},
+		{"truncate", []interface{}{"data.txt", 0}, syscall.EACCES},
+		{"symlink", []interface{}{"/etc/passwd", "out/passwd"}, nil},
+		{"symlink", []interface{}{"out/a", "a"}, syscall.EACCES},
+		{"link", []interface{}{"data.txt", "out/data.txt"}, syscall.EACCES},
+		{"link", []interface{}{"out/a", "out/b"}, nil},
+	} {
+		if g, e := p.Check(v.name, v.args), v.err; g != e {
+			t.Errorf("%v: %s: got %v, expected %v", i, String(v.name, v.args), g, e)
+		}
+	}
+
+	if runtime.GOOS != "windows" {
+		for i, v := range []struct {
+			name string
+			args []interface{}
+			err  error
+		}{
+			{"open", []interface{}{"out/escape/data.txt", syscall.O_WRONLY | syscall.O_CREAT, 0644}, syscall.EACCES},
+			{"truncate", []interface{}{"out/escape", 0}, syscall.EACCES},
+			{"unlink", []interface{}{"out/escape"}, nil},
+		} {
+			if g, e := p.Check(v.name, v.args), v.err; g != e {
+				t.Errorf("%v: %s: got %v, expected %v", i, String(v.name, v.args), g, e)
+			}
+		}
+	}
+
+	called := false
+	n, err := p.Intercept("getenv", []interface{}{"HOME"}, func() (int64, error) { called = true; return 42, nil })
+	if called || n != 0 || err != syscall.EPERM {
+		t.Fatal(called, n, err)
+	}
+
+	if g, e := log.String(), "policy: denied getenv(\"HOME\"): operation not permitted\n"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	// Relative paths follow the working directory of the program.
+	if _, err := p.Intercept("chdir", []interface{}{"out"}, func() (int64, error) { return 0, nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Check("open", []interface{}{"data.txt", syscall.O_RDWR, 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	p = &Policy{Allow: []string{"memory", "write"}}
+	if err := p.Check("malloc", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Check("open", []interface{}{"data.txt", syscall.O_RDONLY, 0}); err != syscall.EPERM {
+		t.Fatal(err)
+	}
+
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&Policy{Deny: []string{"proces"}}).Validate(); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	host := func() (int64, error) { return 3, nil }
+	run := func(d *Deterministic) string {
+		var out []string
+		tm := make([]byte, 8)
+		n, _ := d.Intercept("time", []interface{}{tm}, host)
+		out = append(out, fmt.Sprint(n, get(tm, 0, 8)))
+		tv := make([]byte, 16)
+		d.Intercept("gettimeofday", []interface{}{tv, nil}, host)
+		out = append(out, fmt.Sprint(get(tv, 0, 8), get(tv, 1, 8)))
+		ts := make([]byte, 8)
+		d.Intercept("clock_gettime", []interface{}{1, ts}, host)
+		out = append(out, fmt.Sprint(get(ts, 0, 4), get(ts, 1, 4)))
+		d.Intercept("sleep", []interface{}{2}, host)
+		n, _ = d.Intercept("clock", nil, host)
+		out = append(out, fmt.Sprint(n))
+		n, _ = d.Intercept("getpid", nil, host)
+		out = append(out, fmt.Sprint(n))
+		fd, _ := d.Intercept("open", []interface{}{"/dev/urandom", syscall.O_RDONLY, 0}, host)
+		b := make([]byte, 8)
+		n, _ = d.Intercept("read", []interface{}{int(fd), b, 4}, func() (int64, error) { panic("unreachable") })
+		out = append(out, fmt.Sprintf("%v %x", n, b))
+		d.Intercept("close", []interface{}{int(fd)}, host)
+		n, _ = d.Intercept("read", []interface{}{int(fd), b, 4}, host)
+		out = append(out, fmt.Sprint(n))
+		return strings.Join(out, "|")
+	}
+
+	g := run(&Deterministic{Seed: 42})
+	if e := run(&Deterministic{Seed: 42}); g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	if e := run(&Deterministic{Seed: 43}); g == e {
+		t.Fatalf("seed ignored: %q", g)
+	}
+
+	a := strings.Split(g, "|")
+	if g, e := a[0], "946684800 946684800"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	if g, e := a[1], "946684800 2000"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	if g, e := a[2], "0 3000000"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	if g, e := a[3], "2004000"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	d := &Deterministic{Step: time.Microsecond}
+	d.Now()
+	if g, e := d.Now(), DefaultStart.Add(2*time.Microsecond); !g.Equal(e) {
+		t.Fatalf("got %v, expected %v", g, e)
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	var rec bytes.Buffer
+	r, err := NewRecorder(&rec, Recording{Args: []string{"a.out", "data.txt"}, Argv0: "prog", BuildID: "42", Env: []string{"A=B"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	prog := func(f func(string, []interface{}, func() (int64, error)) (int64, error), data string) string {
+		var a []string
+		fd, err := f("open", []interface{}{"data.txt", syscall.O_RDONLY, 0}, func() (int64, error) { return 5, nil })
+		a = append(a, fmt.Sprint(fd, err))
+		b := make([]byte, 16)
+		n, err := f("read", []interface{}{int(fd), b, len(b)}, func() (int64, error) { return int64(copy(b, data)), nil })
+		a = append(a, fmt.Sprint(n, err, string(b[:n])))
+		n, err = f("write", []interface{}{1, b[:n], int(n)}, func() (int64, error) { out.Write(b[:n]); return n, nil })
+		a = append(a, fmt.Sprint(n, err))
+		n, err = f("unlink", []interface{}{"data.txt"}, func() (int64, error) { return -1, syscall.ENOENT })
+		a = append(a, fmt.Sprint(n, err))
+		return strings.Join(a, "|")
+	}
+	e := prog(r.Intercept, "recorded")
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewReplayer(bytes.NewReader(rec.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := fmt.Sprintln(p.Argv(p.Args), p.BuildID, p.Env), "[prog data.txt] 42 [A=B]\n"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	if g := prog(p.Intercept, "changed"); g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	if g, e := out.String(), "recordedrecorded"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	if _, err := p.Intercept("read", []interface{}{0, []byte{0}, 1}, nil); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	p, _ = NewReplayer(bytes.NewReader(rec.Bytes()))
+	if _, err := p.Intercept("unlink", []interface{}{"data.txt"}, nil); err == nil || !strings.Contains(err.Error(), "recorded open") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package host
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const clocksPerSec = 1000000 // CLOCKS_PER_SEC
+
+var (
+	// DefaultStart is the initial time of the virtual clock used when
+	// Deterministic.Start is zero.
+	DefaultStart = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	randomDevices = map[string]bool{
+		"/dev/random":  true,
+		"/dev/urandom": true,
+	}
+)
+
+// Deterministic is an interceptor making a program independent of the host
+// clock, process ID and random sources. Runs using equal Deterministic
+// configurations and equal input produce equal output.
+//
+// The time seen by the program is virtual and does not depend on the host
+// clock. Sleeping only advances the virtual clock.
+type Deterministic struct {
+	// Seed determines the process ID of the program and the content read
+	// from /dev/random and /dev/urandom.
+	Seed int64
+
+	// Start is the initial time of the virtual clock. Zero selects
+	// DefaultStart.
+	Start time.Time
+
+	// Step advances the virtual clock every time the program reads it.
+	// Zero selects one millisecond.
+	Step time.Duration
+
+	elapsed time.Duration // Clock reads and sleeps.
+	mu      sync.Mutex
+	once    sync.Once
+	pid     int64
+	random  map[int64]bool // Open random device fds.
+	rnd     *rand.Rand
+}
+
+func (d *Deterministic) init() {
+	if d.Start.IsZero() {
+		d.Start = DefaultStart
+	}
+	if d.Step == 0 {
+		d.Step = time.Millisecond
+	}
+	d.rnd = rand.New(rand.NewSource(d.Seed))
+	d.pid = 2 + d.rnd.Int63n(32766)
+	d.random = map[int64]bool{}
+}
+
+// Pid returns the process ID seen by the program.
+func (d *Deterministic) Pid() int {
+	d.once.Do(d.init)
+	return int(d.pid)
+}
+
+// Now returns the current time of the virtual clock and advances it by Step.
+func (d *Deterministic) Now() time.Time {
+	d.once.Do(d.init)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.Start.Add(d.now())
+}
+
+func (d *Deterministic) now() time.Duration {
+	d.elapsed += d.Step
+	return d.elapsed
+}
+
+func (d *Deterministic) sleep(t time.Duration) {
+	if t > 0 {
+		d.mu.Lock()
+		d.elapsed += t
+		d.mu.Unlock()
+	}
+}
+
+// Intercept is the interceptor of d, see the package documentation.
+func (d *Deterministic) Intercept(name string, args []interface{}, call func() (int64, error)) (int64, error) {
+	d.once.Do(d.init)
+	switch name {
+	case "time":
+		t := d.Now().Unix()
+		if b := buf(args, 0); len(b) != 0 {
+			put(b, 0, len(b), t)
+		}
+		return t, nil
+	case "clock":
+		d.mu.Lock()
+		t := d.now()
+		d.mu.Unlock()
+		return int64(t / (time.Second / clocksPerSec)), nil
+	case "gettimeofday":
+		if b := buf(args, 0); len(b) != 0 {
+			t := d.Now()
+			put(b, 0, len(b)/2, t.Unix())
+			put(b, 1, len(b)/2, int64(t.Nanosecond()/1000))
+		}
+		return 0, nil
+	case "clock_gettime":
+		d.mu.Lock()
+		t := d.now()
+		d.mu.Unlock()
+		if id, _ := integer(args, 0); id == 0 { // CLOCK_REALTIME
+			t = time.Duration(d.Start.Add(t).UnixNano())
+		}
+		if b := buf(args, 1); len(b) != 0 {
+			put(b, 0, len(b)/2, int64(t/time.Second))
+			put(b, 1, len(b)/2, int64(t%time.Second))
+		}
+		return 0, nil
+	case "sleep":
+		n, _ := integer(args, 0)
+		d.sleep(time.Duration(n) * time.Second)
+		return 0, nil
+	case "usleep":
+		n, _ := integer(args, 0)
+		d.sleep(time.Duration(n) * time.Microsecond)
+		return 0, nil
+	case "nanosleep":
+		if b := buf(args, 0); len(b) != 0 {
+			d.sleep(time.Duration(get(b, 0, len(b)/2))*time.Second + time.Duration(get(b, 1, len(b)/2)))
+		}
+		return 0, nil
+	case "getpid":
+		return d.pid, nil
+	case "getppid":
+		return 1, nil
+	case "getrandom":
+		b := buf(args, 0)
+		d.mu.Lock()
+		d.rnd.Read(b)
+		d.mu.Unlock()
+		return int64(len(b)), nil
+	case "open":
+		fd, err := call()
+		if s, ok := str(args, 0); ok && randomDevices[s] && err == nil {
+			d.mu.Lock()
+			d.random[fd] = true
+			d.mu.Unlock()
+		}
+		return fd, err
+	case "read":
+		fd, _ := integer(args, 0)
+		d.mu.Lock()
+		random := d.random[fd]
+		d.mu.Unlock()
+		if !random {
+			break
+		}
+
+		b := buf(args, 1)
+		if n, ok := integer(args, 2); ok && n < int64(len(b)) {
+			b = b[:n]
+		}
+		d.mu.Lock()
+		d.rnd.Read(b)
+		d.mu.Unlock()
+		return int64(len(b)), nil
+	case "close":
+		fd, _ := integer(args, 0)
+		d.mu.Lock()
+		delete(d.random, fd)
+		d.mu.Unlock()
+	}
+	return call()
+}
+
+// buf returns args[i] if it is a memory buffer of the program.
+func buf(args []interface{}, i int) []byte {
+	if i < len(args) {
+		if b, ok := args[i].([]byte); ok {
+			return b
+		}
+	}
+	return nil
+}
+
+// str returns args[i] if it is a C string.
+func str(args []interface{}, i int) (string, bool) {
+	if i < len(args) {
+		s, ok := args[i].(string)
+		return s, ok
+	}
+	return "", false
+}
+
+// integer returns args[i] if it is an integer.
+func integer(args []interface{}, i int) (int64, bool) {
+	if i >= len(args) {
+		return 0, false
+	}
+
+	switch x := args[i].(type) {
+	case int:
+		return int64(x), true
+	case int32:
+		return int64(x), true
+	case int64:
+		return x, true
+	case uintptr:
+		return int64(x), true
+	}
+	return 0, false
+}
+
+// put stores v to the i-th field of width w in b. All supported targets are
+// little endian.
+func put(b []byte, i, w int, v int64) {
+	switch b = b[i*w:]; w {
+	case 4:
+		binary.LittleEndian.PutUint32(b, uint32(v))
+	case 8:
+		binary.LittleEndian.PutUint64(b, uint64(v))
+	}
+}
+
+// get returns the i-th field of width w in b.
+func get(b []byte, i, w int) int64 {
+	switch b = b[i*w:]; w {
+	case 4:
+		return int64(int32(binary.LittleEndian.Uint32(b)))
+	case 8:
+		return int64(binary.LittleEndian.Uint64(b))
+	}
+	return 0
+}
@@ -0,0 +1,55 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package host provides interceptors of the calls programs executed by the
+// virtual machine make to the host, ie. the calls 99strace prints.
+//
+// An interceptor is a function having the signature of the Intercept
+// methods. It receives the name of the call, its arguments and a function
+// performing the call on the host. The result of an interceptor is what the
+// program sees: the return value of the C function and, if not nil, a
+// syscall.Errno stored to errno. Any other error aborts the program. Arguments
+// of type string are C strings, arguments of type []byte are the memory
+// buffers of the program. The virtual machine has no option installing an
+// interceptor, a Go program embedding it calls the interceptor wherever it
+// performs calls on behalf of the program, eg. in its FFI functions.
+//
+// For example, an FFI function performing time for a program run
+// deterministically
+//
+//	d := &host.Deterministic{Seed: 42}
+//	...
+//	t, err := d.Intercept("time", []interface{}{buf}, func() (int64, error) { return time.Now().Unix(), nil })
+package host
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Groups maps the call group names accepted by Policy to the calls they
+// consist of.
+var Groups = map[string][]string{
+	"env":     {"getenv", "setenv", "unsetenv", "putenv", "clearenv"},
+	"file":    {"open", "creat", "close", "read", "write", "lseek", "stat", "fstat", "lstat", "access", "unlink", "rename", "mkdir", "rmdir", "opendir", "readdir", "closedir", "getcwd", "chdir", "isatty", "symlink", "link", "chmod", "truncate"},
+	"memory":  {"malloc", "calloc", "realloc", "freep"},
+	"process": {"fork", "vfork", "execve", "execv", "execvp", "system", "popen", "pclose", "kill", "wait", "waitpid"},
+	"time":    {"time", "clock", "gettimeofday", "clock_gettime", "nanosleep", "sleep", "usleep"},
+}
+
+// String formats a call the way 99strace does.
+func String(name string, args []interface{}) string {
+	a := make([]string, len(args))
+	for i, v := range args {
+		switch x := v.(type) {
+		case string:
+			a[i] = fmt.Sprintf("%q", x)
+		case []byte:
+			a[i] = fmt.Sprintf("[%d]byte", len(x))
+		default:
+			a[i] = fmt.Sprint(x)
+		}
+	}
+	return fmt.Sprintf("%s(%s)", name, strings.Join(a, ", "))
+}
@@ -0,0 +1,248 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package host
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const writeFlags = syscall.O_WRONLY | syscall.O_RDWR | syscall.O_CREAT | syscall.O_TRUNC | syscall.O_APPEND
+
+// pointerResults lists the calls returning a pointer. They return 0 when
+// denied, all other calls return -1.
+var pointerResults = map[string]bool{
+	"calloc":  true,
+	"getcwd":  true,
+	"getenv":  true,
+	"malloc":  true,
+	"opendir": true,
+	"popen":   true,
+	"readdir": true,
+	"realloc": true,
+}
+
+// Policy is an interceptor denying calls of the program. Denied calls fail
+// with EPERM, modifications of files outside of WritePaths fail with EACCES.
+type Policy struct {
+	// Allow lists the calls or call groups the program may make. If not
+	// empty, all other calls are denied.
+	Allow []string
+
+	// Deny lists the calls or call groups the program may not make. Deny
+	// takes precedence over Allow.
+	Deny []string
+
+	// Dir is the working directory the program starts in, relative to the
+	// working directory of the process. Relative paths passed by the
+	// program are resolved against the working directory of the program,
+	// which follows the successful chdir calls seen by Intercept.
+	Dir string
+
+	// Log, if not nil, receives a line for every denied call.
+	Log io.Writer
+
+	// WritePaths, if not empty, lists the directories below which the
+	// program may create, modify or remove files. Relative write paths are
+	// relative to Dir.
+	WritePaths []string
+
+	allow      map[string]bool
+	deny       map[string]bool
+	mu         sync.Mutex
+	once       sync.Once
+	wd         string
+	writePaths []string
+}
+
+func (p *Policy) init() {
+	p.allow = expand(p.Allow)
+	p.deny = expand(p.Deny)
+	p.wd, _ = filepath.Abs(p.Dir)
+	if wd, err := evalSymlinks(p.wd); err == nil {
+		p.wd = wd
+	}
+	for _, v := range p.WritePaths {
+		if v, err := p.abs(v); err == nil {
+			p.writePaths = append(p.writePaths, v)
+		}
+	}
+}
+
+// Validate returns an error if Allow or Deny has a name which is neither a
+// call group nor a call of a group.
+func (p *Policy) Validate() error {
+	calls := map[string]bool{}
+	for _, v := range Groups {
+		for _, v := range v {
+			calls[v] = true
+		}
+	}
+	for _, v := range append(p.Allow[:len(p.Allow):len(p.Allow)], p.Deny...) {
+		if _, ok := Groups[v]; !ok && !calls[v] {
+			return fmt.Errorf("unknown call or call group: %s", v)
+		}
+	}
+	return nil
+}
+
+// expand returns the set of calls in a, with group names replaced by the
+// calls of the group.
+func expand(a []string) map[string]bool {
+	if len(a) == 0 {
+		return nil
+	}
+
+	m := map[string]bool{}
+	for _, v := range a {
+		if g, ok := Groups[v]; ok {
+			for _, v := range g {
+				m[v] = true
+			}
+			continue
+		}
+
+		m[v] = true
+	}
+	return m
+}
+
+// Check returns syscall.EPERM or syscall.EACCES if p denies the call, or nil
+// otherwise.
+func (p *Policy) Check(name string, args []interface{}) error {
+	p.once.Do(p.init)
+	if p.deny[name] || p.allow != nil && !p.allow[name] {
+		return syscall.EPERM
+	}
+
+	if p.writePaths == nil {
+		return nil
+	}
+
+	for _, v := range written(name, args) {
+		if !p.writable(v.path, v.follow) {
+			return syscall.EACCES
+		}
+	}
+	return nil
+}
+
+// abs returns path resolved against the working directory of the program,
+// with symbolic links evaluated.
+func (p *Policy) abs(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		p.mu.Lock()
+		path = filepath.Join(p.wd, path)
+		p.mu.Unlock()
+	}
+	return evalSymlinks(path)
+}
+
+// evalSymlinks returns path with symbolic links evaluated. The last component
+// of path need not exist.
+func evalSymlinks(path string) (string, error) {
+	r, err := filepath.EvalSymlinks(path)
+	if err == nil || !os.IsNotExist(err) {
+		return r, err
+	}
+
+	if r, err = filepath.EvalSymlinks(filepath.Dir(path)); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(r, filepath.Base(path)), nil
+}
+
+// writable reports whether path is below one of the write paths. A symbolic
+// link in the last component of path is followed only if follow is true.
+func (p *Policy) writable(path string, follow bool) bool {
+	var err error
+	switch {
+	case follow:
+		path, err = p.abs(path)
+	default:
+		var dir string
+		if dir, err = p.abs(filepath.Dir(path)); err == nil {
+			path = filepath.Join(dir, filepath.Base(path))
+		}
+	}
+	if err != nil {
+		return false
+	}
+
+	for _, v := range p.writePaths {
+		if path == v || strings.HasPrefix(path, v+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+type target struct {
+	path   string
+	follow bool // Whether a symbolic link in the last component is followed.
+}
+
+// written returns the paths name modifies.
+func written(name string, args []interface{}) (r []target) {
+	arg := func(i int, follow bool) {
+		if i < len(args) {
+			if s, ok := args[i].(string); ok {
+				r = append(r, target{s, follow})
+			}
+		}
+	}
+	switch name {
+	case "open":
+		if len(args) > 1 {
+			if flags, ok := args[1].(int); ok && flags&writeFlags == 0 {
+				return nil
+			}
+		}
+		arg(0, true)
+	case "creat", "chmod", "truncate":
+		arg(0, true)
+	case "mkdir", "rmdir", "unlink":
+		arg(0, false)
+	case "link", "rename":
+		arg(0, false)
+		arg(1, false)
+	case "symlink":
+		arg(1, false)
+	}
+	return r
+}
+
+// Intercept is the interceptor of p, see the package documentation.
+func (p *Policy) Intercept(name string, args []interface{}, call func() (int64, error)) (int64, error) {
+	err := p.Check(name, args)
+	if err == nil {
+		n, err := call()
+		if name == "chdir" && n == 0 && err == nil && len(args) != 0 {
+			if s, ok := args[0].(string); ok {
+				if wd, err := p.abs(s); err == nil {
+					p.mu.Lock()
+					p.wd = wd
+					p.mu.Unlock()
+				}
+			}
+		}
+		return n, err
+	}
+
+	if p.Log != nil {
+		fmt.Fprintf(p.Log, "policy: denied %s: %v\n", String(name, args), err)
+	}
+	if pointerResults[name] {
+		return 0, err
+	}
+
+	return -1, err
+}
@@ -0,0 +1,205 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package host
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"sync"
+	"syscall"
+
+	"github.com/cznic/99c/exe"
+	"github.com/cznic/virtual"
+)
+
+const recordingVersion = 1
+
+// passThrough lists the calls neither recorded nor replayed. They manage the
+// memory of the virtual machine and must always be performed.
+var passThrough = map[string]bool{
+	"calloc":  true,
+	"freep":   true,
+	"malloc":  true,
+	"realloc": true,
+}
+
+// Recording describes a recorded execution.
+type Recording struct {
+	// Args are the arguments of the program, starting with its name.
+	Args []string
+
+	// Argv0, if not empty, replaces Args[0] as seen by the program.
+	Argv0 string
+
+	// BuildID is the build ID of the executable. See exe.BuildID.
+	BuildID string
+
+	// Env is the environment of the program.
+	Env []string
+
+	Version int
+}
+
+// CheckBinary returns an error if b is not the recorded executable.
+func (r *Recording) CheckBinary(b *virtual.Binary) error {
+	if id := exe.BuildID(b); r.BuildID != "" && id != r.BuildID {
+		return fmt.Errorf("executable build ID %s does not match the recorded build ID %s", id, r.BuildID)
+	}
+
+	return nil
+}
+
+// Argv returns args, the arguments of the executable, as seen by the recorded
+// program.
+func (r *Recording) Argv(args []string) []string {
+	if r.Argv0 == "" || len(args) == 0 {
+		return args
+	}
+
+	return append([]string{r.Argv0}, args[1:]...)
+}
+
+// event is a recorded call.
+type event struct {
+	Bufs  map[int][]byte // Content of the changed buffer arguments.
+	Errno syscall.Errno
+	Name  string
+	Ret   int64
+}
+
+// Recorder is an interceptor recording the results of the calls of a program
+// so that its execution can later be reproduced by a Replayer.
+type Recorder struct {
+	Recording
+	enc *gob.Encoder
+	err error
+	mu  sync.Mutex
+}
+
+// NewRecorder writes the header of a recording to w and returns a Recorder
+// writing the calls of the program to w.
+func NewRecorder(w io.Writer, r Recording) (*Recorder, error) {
+	r.Version = recordingVersion
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(&r); err != nil {
+		return nil, err
+	}
+
+	return &Recorder{Recording: r, enc: enc}, nil
+}
+
+// Err returns the first error encountered while writing the recording.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.err
+}
+
+// Intercept is the interceptor of r, see the package documentation.
+func (r *Recorder) Intercept(name string, args []interface{}, call func() (int64, error)) (int64, error) {
+	if passThrough[name] {
+		return call()
+	}
+
+	var before [][]byte
+	for _, v := range args {
+		b, _ := v.([]byte)
+		before = append(before, append([]byte(nil), b...))
+	}
+	ret, err := call()
+	ev := event{Name: name, Ret: ret}
+	if err != nil {
+		errno, ok := err.(syscall.Errno)
+		if !ok {
+			return ret, err
+		}
+
+		ev.Errno = errno
+	}
+	for i, v := range args {
+		if b, ok := v.([]byte); ok && !bytes.Equal(b, before[i]) {
+			if ev.Bufs == nil {
+				ev.Bufs = map[int][]byte{}
+			}
+			ev.Bufs[i] = b
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err == nil {
+		r.err = r.enc.Encode(&ev)
+	}
+	return ret, err
+}
+
+// Replayer is an interceptor reproducing a recorded execution. Instead of
+// performing the calls of the program on the host, their recorded results
+// are returned. Only writes to stdout and stderr are performed so that the
+// output of the program is visible. If the program makes a call different
+// from the recorded one, it is aborted.
+type Replayer struct {
+	Recording
+	dec *gob.Decoder
+	mu  sync.Mutex
+	n   int
+}
+
+// NewReplayer returns a Replayer reading a recording from r.
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	dec := gob.NewDecoder(r)
+	var rec Recording
+	if err := dec.Decode(&rec); err != nil {
+		return nil, fmt.Errorf("invalid recording: %v", err)
+	}
+
+	if rec.Version > recordingVersion {
+		return nil, fmt.Errorf("unsupported recording version %v", rec.Version)
+	}
+
+	return &Replayer{Recording: rec, dec: dec}, nil
+}
+
+// Intercept is the interceptor of r, see the package documentation.
+func (r *Replayer) Intercept(name string, args []interface{}, call func() (int64, error)) (int64, error) {
+	if passThrough[name] {
+		return call()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.n++
+	var ev event
+	if err := r.dec.Decode(&ev); err != nil {
+		if err == io.EOF {
+			return -1, fmt.Errorf("replay diverged at call #%v %s: end of recording", r.n, String(name, args))
+		}
+
+		return -1, fmt.Errorf("invalid recording: %v", err)
+	}
+
+	if ev.Name != name {
+		return -1, fmt.Errorf("replay diverged at call #%v %s: recorded %s", r.n, String(name, args), ev.Name)
+	}
+
+	if fd, _ := integer(args, 0); name == "write" && (fd == 1 || fd == 2) {
+		call()
+	}
+	for i, v := range ev.Bufs {
+		if b := buf(args, i); b != nil {
+			copy(b, v)
+		}
+	}
+	if ev.Errno != 0 {
+		return ev.Ret, ev.Errno
+	}
+
+	return ev.Ret, nil
+}
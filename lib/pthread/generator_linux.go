@@ -0,0 +1,25 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:generate sh -c "mkdir -p $HOME/.99c/include $HOME/.99c/lib"
+//go:generate sh -c "cp include/pthread.h $HOME/.99c/include/"
+//go:generate sh -c "99c -shared -Iinclude -o $HOME/.99c/lib/libpthread.so src/pthread.c"
+//go:generate sh -c "echo \"dependency_libs=''\" > $HOME/.99c/lib/libpthread.la"
+
+// Package pthread installs a 99c version of libpthread on your system.
+//
+// Run
+//
+//     $ go generate
+//
+// to install the package on your system. The package will be installed in
+// '$HOME/.99c'. Currently supported only on Linux.
+//
+// The virtual machine provides no way for a C program to start a thread, so
+// the library supports single threaded programs only. pthread_create fails
+// with EAGAIN, locking a mutex already locked fails with EDEADLK unless the
+// mutex is recursive and waiting on a condition variable fails with EDEADLK,
+// or ETIMEDOUT when a timeout is given. Thread specific data, pthread_once,
+// pthread_self and pthread_exit work as usual.
+package pthread
@@ -0,0 +1,276 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// The virtual machine provides no way to start a thread, so the only thread of
+// a program is its main thread.
+
+#include <errno.h>
+#include <pthread.h>
+#include <stdlib.h>
+
+#define MAIN_THREAD 1
+#define DEFAULT_STACK_SIZE (1 << 20)
+
+static struct {
+	int used;
+	void (*destructor)(void *);
+	void *value;
+} keys[PTHREAD_KEYS_MAX];
+
+int pthread_attr_destroy(pthread_attr_t *attr)
+{
+	return 0;
+}
+
+int pthread_attr_getdetachstate(const pthread_attr_t *attr, int *state)
+{
+	*state = attr->__detachstate;
+	return 0;
+}
+
+int pthread_attr_getstacksize(const pthread_attr_t *attr, unsigned long *size)
+{
+	*size = attr->__stacksize;
+	return 0;
+}
+
+int pthread_attr_init(pthread_attr_t *attr)
+{
+	attr->__detachstate = PTHREAD_CREATE_JOINABLE;
+	attr->__stacksize = DEFAULT_STACK_SIZE;
+	return 0;
+}
+
+int pthread_attr_setdetachstate(pthread_attr_t *attr, int state)
+{
+	if (state != PTHREAD_CREATE_JOINABLE && state != PTHREAD_CREATE_DETACHED) {
+		return EINVAL;
+	}
+
+	attr->__detachstate = state;
+	return 0;
+}
+
+int pthread_attr_setstacksize(pthread_attr_t *attr, unsigned long size)
+{
+	if (size == 0) {
+		return EINVAL;
+	}
+
+	attr->__stacksize = size;
+	return 0;
+}
+
+// No other thread can signal the condition variable.
+
+int pthread_cond_broadcast(pthread_cond_t *cond)
+{
+	return 0;
+}
+
+int pthread_cond_destroy(pthread_cond_t *cond)
+{
+	return 0;
+}
+
+int pthread_cond_init(pthread_cond_t *cond, const pthread_condattr_t *attr)
+{
+	cond->__unused = 0;
+	return 0;
+}
+
+int pthread_cond_signal(pthread_cond_t *cond)
+{
+	return 0;
+}
+
+int pthread_cond_timedwait(pthread_cond_t *cond, pthread_mutex_t *mutex, const struct timespec *abstime)
+{
+	return ETIMEDOUT;
+}
+
+int pthread_cond_wait(pthread_cond_t *cond, pthread_mutex_t *mutex)
+{
+	return EDEADLK;
+}
+
+int pthread_condattr_destroy(pthread_condattr_t *attr)
+{
+	return 0;
+}
+
+int pthread_condattr_init(pthread_condattr_t *attr)
+{
+	attr->__unused = 0;
+	return 0;
+}
+
+int pthread_create(pthread_t *thread, const pthread_attr_t *attr, void *(*start)(void *), void *arg)
+{
+	return EAGAIN;
+}
+
+int pthread_detach(pthread_t thread)
+{
+	return thread == MAIN_THREAD ? 0 : ESRCH;
+}
+
+int pthread_equal(pthread_t a, pthread_t b)
+{
+	return a == b;
+}
+
+// pthread_exit of the main thread runs the destructors of the thread specific
+// data and terminates the process with exit code 0.
+void pthread_exit(void *result)
+{
+	for (int i = 0; i < PTHREAD_DESTRUCTOR_ITERATIONS; i++) {
+		int called = 0;
+		for (int k = 0; k < PTHREAD_KEYS_MAX; k++) {
+			void *v = keys[k].value;
+			if (!keys[k].used || !keys[k].destructor || !v) {
+				continue;
+			}
+
+			keys[k].value = 0;
+			keys[k].destructor(v);
+			called = 1;
+		}
+		if (!called) {
+			break;
+		}
+	}
+	exit(0);
+}
+
+void *pthread_getspecific(pthread_key_t key)
+{
+	return key < PTHREAD_KEYS_MAX ? keys[key].value : 0;
+}
+
+int pthread_join(pthread_t thread, void **result)
+{
+	return thread == MAIN_THREAD ? EDEADLK : ESRCH;
+}
+
+int pthread_key_create(pthread_key_t *key, void (*destructor)(void *))
+{
+	for (int k = 0; k < PTHREAD_KEYS_MAX; k++) {
+		if (!keys[k].used) {
+			keys[k].used = 1;
+			keys[k].destructor = destructor;
+			keys[k].value = 0;
+			*key = k;
+			return 0;
+		}
+	}
+	return EAGAIN;
+}
+
+int pthread_key_delete(pthread_key_t key)
+{
+	if (key >= PTHREAD_KEYS_MAX || !keys[key].used) {
+		return EINVAL;
+	}
+
+	keys[key].used = 0;
+	return 0;
+}
+
+int pthread_mutex_destroy(pthread_mutex_t *mutex)
+{
+	return mutex->__count ? EBUSY : 0;
+}
+
+int pthread_mutex_init(pthread_mutex_t *mutex, const pthread_mutexattr_t *attr)
+{
+	mutex->__kind = attr ? attr->__kind : PTHREAD_MUTEX_DEFAULT;
+	mutex->__count = 0;
+	return 0;
+}
+
+// Locking a mutex already locked by the only thread would block forever,
+// except for a recursive mutex.
+int pthread_mutex_lock(pthread_mutex_t *mutex)
+{
+	if (mutex->__count && mutex->__kind != PTHREAD_MUTEX_RECURSIVE) {
+		return EDEADLK;
+	}
+
+	mutex->__count++;
+	return 0;
+}
+
+int pthread_mutex_trylock(pthread_mutex_t *mutex)
+{
+	if (mutex->__count && mutex->__kind != PTHREAD_MUTEX_RECURSIVE) {
+		return EBUSY;
+	}
+
+	mutex->__count++;
+	return 0;
+}
+
+int pthread_mutex_unlock(pthread_mutex_t *mutex)
+{
+	if (!mutex->__count) {
+		return EPERM;
+	}
+
+	mutex->__count--;
+	return 0;
+}
+
+int pthread_mutexattr_destroy(pthread_mutexattr_t *attr)
+{
+	return 0;
+}
+
+int pthread_mutexattr_gettype(const pthread_mutexattr_t *attr, int *kind)
+{
+	*kind = attr->__kind;
+	return 0;
+}
+
+int pthread_mutexattr_init(pthread_mutexattr_t *attr)
+{
+	attr->__kind = PTHREAD_MUTEX_DEFAULT;
+	return 0;
+}
+
+int pthread_mutexattr_settype(pthread_mutexattr_t *attr, int kind)
+{
+	switch (kind) {
+	case PTHREAD_MUTEX_NORMAL:
+	case PTHREAD_MUTEX_RECURSIVE:
+	case PTHREAD_MUTEX_ERRORCHECK:
+		attr->__kind = kind;
+		return 0;
+	}
+	return EINVAL;
+}
+
+int pthread_once(pthread_once_t *once, void (*init)(void))
+{
+	if (!*once) {
+		*once = 1;
+		init();
+	}
+	return 0;
+}
+
+pthread_t pthread_self(void)
+{
+	return MAIN_THREAD;
+}
+
+int pthread_setspecific(pthread_key_t key, const void *value)
+{
+	if (key >= PTHREAD_KEYS_MAX || !keys[key].used) {
+		return EINVAL;
+	}
+
+	keys[key].value = (void *)value;
+	return 0;
+}
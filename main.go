@@ -6,7 +6,7 @@
 //go:generate go install -tags virtual.profile ./99prof
 //go:generate go install -tags virtual.strace ./99strace
 //go:generate go install -tags virtual.trace ./99trace
-//go:generate go install ./99dump ./99nm ./99run
+//go:generate go install ./99dbg ./99dump ./99nm ./99pack ./99run
 
 package main
 
@@ -17,10 +17,10 @@ import (
 	"go/token"
 	"os"
 	"path/filepath"
-	"runtime"
 	"runtime/debug"
 	"strings"
 
+	"github.com/cznic/99c/exe"
 	"github.com/cznic/cc"
 	"github.com/cznic/ccir"
 	"github.com/cznic/ir"
@@ -68,85 +68,113 @@ type testHooks struct {
 }
 
 type args struct {
-	D        []string // -D
-	E        bool     // -E
-	I        []string // -I
-	L        []string // -L
-	O        string   // -O
-	W        string   // -W
-	args     []string // Non flag arguments in order of appearance.
-	c        bool     // -c
-	g        bool     // -g
-	hooks    testHooks
-	l        []string // -l
-	lib      bool     // -99lib
-	o        string   // -o
-	opts     []cc.Opt // cc flags
-	rdynamic bool     // -rdynamic
-	shared   bool     // -shared
+	B          []string // -B, --prefix
+	D          []string // -D
+	E          bool     // -E
+	I          []string // -I
+	L          []string // -L
+	O          string   // -O
+	W          string   // -W
+	args       []string // Non flag arguments in order of appearance.
+	c          bool     // -c
+	extra      []string // -99extra
+	g          bool     // -g
+	hooks      testHooks
+	l          []string  // -l
+	lib        bool      // -99lib
+	noConfig   bool      // -99noconfig
+	noShebang  bool      // -99noshebang
+	noExtra    []string  // -fno-
+	o          string    // -o
+	opts       []cc.Opt  // cc flags
+	prefixMap  prefixMap // -ffile-prefix-map, -fdebug-prefix-map
+	rdynamic   bool      // -rdynamic
+	shared     bool      // -shared
+	showConfig bool      // -99showconfig
+	std        string    // -std
+	target     string    // -target
 }
 
-func (a *args) extra(name string) cc.Opt {
+// extra returns the cc option enabling the extension name.
+func extra(name string) (cc.Opt, error) {
 	switch name {
 	case "AlignOf":
-		return cc.EnableAlignOf()
+		return cc.EnableAlignOf(), nil
 	case "AlternateKeywords":
-		return cc.EnableAlternateKeywords()
+		return cc.EnableAlternateKeywords(), nil
 	case "AnonymousStructFields":
-		return cc.EnableAnonymousStructFields()
+		return cc.EnableAnonymousStructFields(), nil
 	case "Asm":
-		return cc.EnableAsm()
+		return cc.EnableAsm(), nil
 	case "BuiltinClassifyType":
-		return cc.EnableBuiltinClassifyType()
+		return cc.EnableBuiltinClassifyType(), nil
 	case "BuiltinConstantP":
-		return cc.EnableBuiltinConstantP()
+		return cc.EnableBuiltinConstantP(), nil
 	case "ComputedGotos":
-		return cc.EnableComputedGotos()
+		return cc.EnableComputedGotos(), nil
 	case "DlrInIdentifiers":
-		return cc.EnableDlrInIdentifiers()
+		return cc.EnableDlrInIdentifiers(), nil
 	case "EmptyDeclarations":
-		return cc.EnableEmptyDeclarations()
+		return cc.EnableEmptyDeclarations(), nil
 	case "EmptyDefine":
-		return cc.EnableEmptyDefine()
+		return cc.EnableEmptyDefine(), nil
 	case "EmptyStructs":
-		return cc.EnableEmptyStructs()
+		return cc.EnableEmptyStructs(), nil
 	case "ImaginarySuffix":
-		return cc.EnableImaginarySuffix()
+		return cc.EnableImaginarySuffix(), nil
 	case "ImplicitFuncDef":
-		return cc.EnableImplicitFuncDef()
+		return cc.EnableImplicitFuncDef(), nil
 	case "ImplicitIntType":
-		return cc.EnableImplicitIntType()
+		return cc.EnableImplicitIntType(), nil
 	case "IncludeNext":
-		return cc.EnableIncludeNext()
+		return cc.EnableIncludeNext(), nil
 	case "LegacyDesignators":
-		return cc.EnableLegacyDesignators()
+		return cc.EnableLegacyDesignators(), nil
 	case "NonConstStaticInitExpressions":
-		return cc.EnableNonConstStaticInitExpressions()
+		return cc.EnableNonConstStaticInitExpressions(), nil
 	case "Noreturn":
-		return cc.EnableNoreturn()
+		return cc.EnableNoreturn(), nil
 	case "OmitConditionalOperand":
-		return cc.EnableOmitConditionalOperand()
+		return cc.EnableOmitConditionalOperand(), nil
 	case "OmitFuncArgTypes":
-		return cc.EnableOmitFuncArgTypes()
+		return cc.EnableOmitFuncArgTypes(), nil
 	case "OmitFuncRetType":
-		return cc.EnableOmitFuncRetType()
+		return cc.EnableOmitFuncRetType(), nil
 	case "ParenthesizedCompoundStatemen":
-		return cc.EnableParenthesizedCompoundStatemen()
+		return cc.EnableParenthesizedCompoundStatemen(), nil
 	case "StaticAssert":
-		return cc.EnableStaticAssert()
+		return cc.EnableStaticAssert(), nil
 	case "TypeOf":
-		return cc.EnableTypeOf()
+		return cc.EnableTypeOf(), nil
 	case "UndefExtraTokens":
-		return cc.EnableUndefExtraTokens()
+		return cc.EnableUndefExtraTokens(), nil
 	case "UnsignedEnums":
-		return cc.EnableUnsignedEnums()
+		return cc.EnableUnsignedEnums(), nil
 	case "WideBitFieldTypes":
-		return cc.EnableWideBitFieldTypes()
+		return cc.EnableWideBitFieldTypes(), nil
 	case "WideEnumValues":
-		return cc.EnableWideEnumValues()
+		return cc.EnableWideEnumValues(), nil
 	}
-	exit(2, "unknown -99extra argument: %s", name)
-	panic("unreachable")
+	return nil, fmt.Errorf("unknown -99extra argument: %s", name)
+}
+
+// define returns the #define directive equivalent to the -D argument arg.
+func define(arg string) string {
+	p := strings.SplitN(arg, "=", 2)
+	if len(p) == 1 {
+		p = append(p, "1")
+	}
+	return fmt.Sprintf("#define %s %s", p[0], p[1])
+}
+
+// defineName returns the name of the macro defined by the #define directive
+// s.
+func defineName(s string) string {
+	s = strings.TrimPrefix(s, "#define ")
+	if i := strings.IndexAny(s, "( "); i >= 0 {
+		s = s[:i]
+	}
+	return s
 }
 
 func (a *args) getopt(args []string) {
@@ -155,17 +183,25 @@ func (a *args) getopt(args []string) {
 		switch {
 		case arg == "-99lib":
 			a.lib = true
-		case strings.HasPrefix(arg, "-D"):
-			if arg == "-D" {
+		case arg == "-99noconfig":
+			a.noConfig = true
+		case arg == "-99noshebang":
+			a.noShebang = true
+		case arg == "-99showconfig":
+			a.showConfig = true
+		case strings.HasPrefix(arg, "-B"):
+			if arg == "-B" {
 				break
 			}
 
 			arg = arg[2:]
-			p := strings.SplitN(arg, "=", 2)
-			if len(p) == 1 {
-				p = append(p, "1")
+			a.B = append(a.B, filepath.SplitList(arg)...)
+		case strings.HasPrefix(arg, "-D"):
+			if arg == "-D" {
+				break
 			}
-			a.D = append(a.D, fmt.Sprintf("#define %s %s", p[0], p[1]))
+
+			a.D = append(a.D, define(arg[2:]))
 		case arg == "-E":
 			a.E = true
 		case strings.HasPrefix(arg, "-I"):
@@ -186,6 +222,16 @@ func (a *args) getopt(args []string) {
 			a.O = arg[2:]
 		case strings.HasPrefix(arg, "-W"):
 			a.W = arg[2:]
+		case strings.HasPrefix(arg, "--prefix="):
+			arg = arg[len("--prefix="):]
+			a.B = append(a.B, filepath.SplitList(arg)...)
+		case arg == "--prefix":
+			if i+1 >= len(args) {
+				exit(2, "missing --prefix argument")
+			}
+
+			a.B = append(a.B, filepath.SplitList(args[i+1])...)
+			args[i+1] = ""
 		case arg == "-ansi":
 			// nop
 		case arg == "-c":
@@ -195,8 +241,23 @@ func (a *args) getopt(args []string) {
 				exit(2, "missing -99extra argument")
 			}
 
-			a.opts = append(a.opts, a.extra(args[i+1]))
+			if _, err := extra(args[i+1]); err != nil {
+				exit(2, "%v", err)
+			}
+
+			a.extra = append(a.extra, args[i+1])
 			args[i+1] = ""
+		case strings.HasPrefix(arg, "-ffile-prefix-map="), strings.HasPrefix(arg, "-fdebug-prefix-map="):
+			if err := a.prefixMap.add(arg[strings.IndexByte(arg, '=')+1:]); err != nil {
+				exit(2, "%v", err)
+			}
+		case strings.HasPrefix(arg, "-fno-"):
+			nm := arg[len("-fno-"):]
+			if _, err := extra(nm); err != nil {
+				exit(2, "unknown -fno- argument: %s", nm)
+			}
+
+			a.noExtra = append(a.noExtra, nm)
 		case arg == "-g":
 			a.g = true
 		case strings.HasPrefix(arg, "-l"):
@@ -216,7 +277,8 @@ func (a *args) getopt(args []string) {
 		case arg == "-pedantic":
 			// nop
 		case arg == "-pthread":
-			//TODO
+			a.D = append(a.D, define("_REENTRANT"))
+			a.l = append(a.l, "pthread")
 		case arg == "-rdynamic":
 			a.rdynamic = true
 		case arg == "-rpath":
@@ -226,6 +288,11 @@ func (a *args) getopt(args []string) {
 
 			a.o = args[i+1]
 			args[i+1] = ""
+		case strings.HasPrefix(arg, "-std="):
+			a.std = arg[len("-std="):]
+			if err := checkStd(a.std); err != nil {
+				exit(2, "%v", err)
+			}
 		case arg == "-shared":
 			a.shared = true
 			//TODO
@@ -236,6 +303,13 @@ func (a *args) getopt(args []string) {
 
 			//TODO
 			args[i+1] = ""
+		case arg == "-target":
+			if i+1 >= len(args) {
+				exit(2, "missing -target argument")
+			}
+
+			a.target = args[i+1]
+			args[i+1] = ""
 		case strings.HasPrefix(arg, "-"):
 			s := ""
 			if arg != "-h" {
@@ -244,6 +318,17 @@ func (a *args) getopt(args []string) {
 			exit(2, `%sFlags:
   -99lib
         Library link mode.
+  -99noconfig
+        Do not read the configuration file.
+  -99noshebang
+        Do not start Linux executables with a #!/usr/bin/env 99run line.
+        Such executables are run by 99run registered with binfmt_misc.
+  -99showconfig
+        Print the effective configuration and exit.
+  -Bprefix
+        Add prefix, or a list of prefixes, to the 99c package prefixes.
+        The include and lib subdirectories of every prefix are added to the
+        system include files search paths and the search paths for -l.
   -Dname
         Equivalent to inserting '#define name 1' at the start of the
         translation unit.
@@ -261,11 +346,23 @@ func (a *args) getopt(args []string) {
         Optimization setting, ignored.
   -Wwarn
         Warning level, ignored.
+  --prefix list
+        Same as -Blist.
   -ansi
         Ignored.
   -c    Suppress the link-edit phase of the compilation, and do not
         remove any object files that are produced.
-  -g    Produce debugging information, ignored.
+  -fdebug-prefix-map=old=new
+        Same as -ffile-prefix-map.
+  -ffile-prefix-map=old=new
+        Replace the prefix old of file names recorded in object files and
+        executables by new. Can be given multiple times, the last matching
+        mapping wins.
+  -fno-<extension>
+        Disable the -99extra extension, even if enabled by -std, -99extra
+        or the configuration file.
+  -g    Produce debugging information. The executable includes the
+        function and line information, used by stack traces and 99dbg.
   -l<name>
         Link with lib<name>.
   -o pathname
@@ -275,7 +372,9 @@ func (a *args) getopt(args []string) {
   -pedantic
         Ignored.
   -pthread
-        Ignored. (TODO)
+        Define _REENTRANT and link with -lpthread. The library is installed
+        by go generate github.com/cznic/99c/lib/pthread. Programs are single
+        threaded, pthread_create fails with EAGAIN.
   -rdynamic
         Ignored. (TODO)
   -rpath pathname
@@ -284,6 +383,13 @@ func (a *args) getopt(args []string) {
         Link mode shared library.
   -soname arg
         Ignored. (TODO)
+  -std=standard
+        Select the language dialect: c89, c99, c11, gnu89, gnu99 or gnu11.
+        Every dialect enables a curated set of -99extra extensions and
+        defines __STDC_VERSION__ accordingly. The gnu dialects enable
+        all the GNU compatible extensions and define __GNUC__.
+  -target GOOS/GOARCH
+        Compile for the specified target instead of the host.
   -99extra flag
      Extra cc flags:
         AlignOf
@@ -361,7 +467,84 @@ func join(a ...interface{}) (r []string) {
 
 func newTask() *task { return &task{} }
 
+// prefixes returns the 99c package prefixes. Those are the -B and --prefix
+// arguments followed by the list in $NINETYNINEC_ROOT. If neither is given,
+// the default prefix is $HOME/.99c.
+func (t *task) prefixes() []string {
+	r := append([]string(nil), t.args.B...)
+	for _, v := range filepath.SplitList(os.Getenv("NINETYNINEC_ROOT")) {
+		if v != "" {
+			r = append(r, v)
+		}
+	}
+	if len(r) == 0 {
+		if h := strutil.Homepath(); h != "" {
+			r = append(r, filepath.Join(h, ".99c"))
+		}
+	}
+	return clean(r)
+}
+
+// config merges the defaults from the configuration file, if any, into
+// t.args and returns the file name.
+func (t *task) config() (string, error) {
+	if t.args.noConfig {
+		return "", nil
+	}
+
+	dir := "."
+	for _, v := range t.args.args {
+		if filepath.Ext(v) == ".c" {
+			dir = filepath.Dir(v)
+			break
+		}
+	}
+	fn, err := findConfig(dir)
+	if err != nil || fn == "" {
+		return "", err
+	}
+
+	c, err := newConfigFile(fn)
+	if err != nil {
+		return "", err
+	}
+
+	c.merge(&t.args)
+	return fn, nil
+}
+
 func (t *task) main() error {
+	cfg, err := t.config()
+	if err != nil {
+		return fatalError("%v", err)
+	}
+
+	if t.args.showConfig {
+		writeConfig(os.Stdout, &t.args, t.prefixes(), cfg)
+		return nil
+	}
+
+	extras := t.args.extra
+	if s := t.args.std; s != "" {
+		extras = join(stdExtra(s), extras)
+	}
+	no := map[string]bool{}
+	for _, v := range t.args.noExtra {
+		no[v] = true
+	}
+	for _, v := range extras {
+		if no[v] {
+			continue
+		}
+
+		o, err := extra(v)
+		if err != nil {
+			return fatalError("%v", err)
+		}
+
+		t.args.opts = append(t.args.opts, o)
+	}
+
 	// -I dir
 	// -iquote dir
 	// -isystem dir
@@ -431,18 +614,26 @@ func (t *task) main() error {
 		t.args.I,             // 3.
 	)
 
-	if h := strutil.Homepath(); h != "" {
-		p := filepath.Join(h, ".99c")
+	for _, p := range t.prefixes() {
 		fi, err := os.Stat(p)
 		if err == nil && fi.IsDir() {
 			sysIncludes = append(sysIncludes, filepath.Join(p, "include"))
-			t.args.L = append(t.args.I, filepath.Join(p, "lib"))
+			t.args.L = append(t.args.L, filepath.Join(p, "lib"))
 		}
 	}
 
 	//TODO- fmt.Println("includes", includes)
 	//TODO- fmt.Println("sysIncludes", sysIncludes)
 
+	D := append(stdPredefined(t.args.std, t.args.D), t.args.D...)
+	tgt := hostTarget()
+	if s := t.args.target; s != "" {
+		var err error
+		if tgt, err = parseTarget(s); err != nil {
+			return fatalError("%v", err)
+		}
+	}
+
 	if len(t.args.args) == 0 {
 		return fatalError("no input files")
 	}
@@ -539,18 +730,13 @@ func (t *task) main() error {
 		}
 		opts = append(opts, t.args.opts...)
 		for _, v := range t.cfiles {
-			model, err := ccir.NewModel()
+			model, err := tgt.model()
 			if err != nil {
 				return fatalError("%v", err)
 			}
 
 			if _, err := cc.Parse(
-				fmt.Sprintf(`
-%s
-#define __arch__ %s
-#define __os__ %s
-#include <builtin.h>
-`, strings.Join(t.args.D, "\n"), runtime.GOARCH, runtime.GOOS),
+				tgt.predefined(D),
 				[]string{v},
 				model,
 				opts...,
@@ -596,18 +782,13 @@ func (t *task) main() error {
 		}
 		opts = append(opts, t.args.opts...)
 		for _, arg := range t.cfiles {
-			model, err := ccir.NewModel()
+			model, err := tgt.model()
 			if err != nil {
 				return fatalError("%v", err)
 			}
 
 			tu, err := cc.Parse(
-				fmt.Sprintf(`
-%s
-#define __arch__ %s
-#define __os__ %s
-#include <builtin.h>
-`, strings.Join(t.args.D, "\n"), runtime.GOARCH, runtime.GOOS),
+				tgt.predefined(D),
 				[]string{arg},
 				model,
 				opts...,
@@ -621,6 +802,7 @@ func (t *task) main() error {
 				return err
 			}
 
+			t.args.prefixMap.apply(o)
 			if p := t.args.hooks.obj; p != nil {
 				*p = ir.Objects{o}
 			}
@@ -659,18 +841,13 @@ func (t *task) main() error {
 		opts = append(opts, t.args.opts...)
 
 		for _, v := range append(t.cfiles, ccir.CRT0Path) {
-			model, err := ccir.NewModel()
+			model, err := tgt.model()
 			if err != nil {
 				return fatalError("%v", err)
 			}
 
 			tu, err := cc.Parse(
-				fmt.Sprintf(`
-%s
-#define __arch__ %s
-#define __os__ %s
-#include <builtin.h>
-`, strings.Join(t.args.D, "\n"), runtime.GOARCH, runtime.GOOS),
+				tgt.predefined(D),
 				[]string{v},
 				model,
 				opts...,
@@ -687,6 +864,9 @@ func (t *task) main() error {
 			obj = append(obj, o)
 		}
 
+		// The line and function information of the executable is
+		// derived from the objects.
+		t.args.prefixMap.apply(obj)
 		var out ir.Objects
 		switch {
 		case t.args.shared:
@@ -737,7 +917,7 @@ func (t *task) main() error {
 			return err
 		}
 
-		if runtime.GOOS == "linux" {
+		if tgt.os == "linux" && !t.args.noShebang {
 			f.WriteString("#!/usr/bin/env 99run\n")
 		}
 
@@ -745,14 +925,18 @@ func (t *task) main() error {
 			bin.Functions = nil
 			bin.Lines = nil
 			if !t.args.lib {
-				start, ok := bin.LookupFunction("_start")
+				start, ok := bin.Sym[ir.NameID(xc.Dict.SID("_start"))]
 				bin.Sym = nil
 				if ok {
 					bin.Sym = map[ir.NameID]int{ir.NameID(xc.Dict.SID("_start")): start}
 				}
 			}
 		}
-		if _, err := bin.WriteTo(f); err != nil {
+		h := &exe.Header{
+			BuildID: exe.BuildID(bin),
+			Target:  tgt.String(),
+		}
+		if err := exe.Write(f, h, bin); err != nil {
 			return err
 		}
 
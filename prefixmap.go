@@ -0,0 +1,141 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"reflect"
+	"strings"
+	"unsafe"
+
+	"github.com/cznic/ir"
+	"github.com/cznic/xc"
+)
+
+var (
+	positionType = reflect.TypeOf(token.Position{})
+	stringIDType = reflect.TypeOf(ir.StringID(0))
+)
+
+// prefixMap holds the -ffile-prefix-map and -fdebug-prefix-map arguments.
+type prefixMap [][2]string
+
+func (m *prefixMap) add(arg string) error {
+	p := strings.SplitN(arg, "=", 2)
+	if len(p) != 2 || p[0] == "" {
+		return fmt.Errorf("invalid prefix map, expected OLD=NEW: %s", arg)
+	}
+
+	*m = append(*m, [2]string{p[0], p[1]})
+	return nil
+}
+
+// path returns s with its prefix replaced according to the last matching
+// OLD=NEW pair, if any.
+func (m prefixMap) path(s string) string {
+	for i := len(m) - 1; i >= 0; i-- {
+		if v := m[i]; strings.HasPrefix(s, v[0]) {
+			return v[1] + s[len(v[0]):]
+		}
+	}
+	return s
+}
+
+// apply rewrites the file names of all positions reachable from v, which is
+// typically an ir.Object or a collection of them. String literals equal to
+// the name of a rewritten file, ie. the expansions of __FILE__, are rewritten
+// as well.
+func (m prefixMap) apply(v interface{}) {
+	if len(m) == 0 {
+		return
+	}
+
+	w := &prefixWalker{m: m, files: map[string]string{}, visited: map[uintptr]struct{}{}}
+	w.walk(reflect.ValueOf(v))
+	if len(w.files) == 0 {
+		return
+	}
+
+	w.strings = true
+	w.visited = map[uintptr]struct{}{}
+	w.walk(reflect.ValueOf(v))
+}
+
+type prefixWalker struct {
+	files   map[string]string // Rewritten file names: old -> new.
+	m       prefixMap
+	strings bool // Second pass: rewrite string literals.
+	visited map[uintptr]struct{}
+}
+
+func (w *prefixWalker) walk(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+
+		if _, ok := w.visited[v.Pointer()]; ok {
+			return
+		}
+
+		w.visited[v.Pointer()] = struct{}{}
+		w.walk(v.Elem())
+	case reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+
+		e := v.Elem()
+		if e.Kind() == reflect.Ptr || !v.CanSet() {
+			w.walk(e)
+			return
+		}
+
+		// Values stored in interfaces are not addressable.
+		c := reflect.New(e.Type()).Elem()
+		c.Set(e)
+		w.walk(c)
+		v.Set(c)
+	case reflect.Struct:
+		if v.Type() == positionType {
+			if w.strings {
+				return
+			}
+
+			if f := v.FieldByName("Filename"); f.CanSet() {
+				if s := w.m.path(f.String()); s != f.String() {
+					w.files[f.String()] = s
+					f.SetString(s)
+				}
+			}
+			return
+		}
+
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Field(i)
+			if !f.CanSet() && f.CanAddr() {
+				// Unexported field.
+				f = reflect.NewAt(f.Type(), unsafe.Pointer(f.UnsafeAddr())).Elem()
+			}
+			if f.CanSet() {
+				w.walk(f)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			w.walk(v.Index(i))
+		}
+	case reflect.Int:
+		if !w.strings || v.Type() != stringIDType || !v.CanSet() {
+			return
+		}
+
+		if s, ok := w.files[string(xc.Dict.S(int(v.Int())))]; ok {
+			v.SetInt(int64(xc.Dict.SID(s)))
+		}
+	}
+}
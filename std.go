@@ -0,0 +1,115 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+)
+
+var (
+	// gnuExtra lists the cc extensions compatible with the GNU dialects.
+	gnuExtra = []string{
+		"AlignOf",
+		"AlternateKeywords",
+		"AnonymousStructFields",
+		"Asm",
+		"BuiltinClassifyType",
+		"BuiltinConstantP",
+		"ComputedGotos",
+		"DlrInIdentifiers",
+		"EmptyDeclarations",
+		"EmptyStructs",
+		"ImaginarySuffix",
+		"IncludeNext",
+		"LegacyDesignators",
+		"NonConstStaticInitExpressions",
+		"OmitConditionalOperand",
+		"ParenthesizedCompoundStatemen",
+		"TypeOf",
+		"UndefExtraTokens",
+		"WideBitFieldTypes",
+		"WideEnumValues",
+	}
+
+	// c89Extra lists the cc extensions providing the C89 features removed
+	// in C99.
+	c89Extra = []string{
+		"ImplicitFuncDef",
+		"ImplicitIntType",
+		"OmitFuncArgTypes",
+		"OmitFuncRetType",
+	}
+
+	// c11Extra lists the cc extensions providing the C11 features 99c
+	// supports.
+	c11Extra = []string{
+		"AlignOf",
+		"AnonymousStructFields",
+		"Noreturn",
+		"StaticAssert",
+	}
+
+	stds = map[string]struct {
+		extra   [][]string
+		gnu     bool
+		version string // __STDC_VERSION__
+	}{
+		"c89":   {[][]string{c89Extra}, false, ""},
+		"c99":   {nil, false, "199901L"},
+		"c11":   {[][]string{c11Extra}, false, "201112L"},
+		"gnu89": {[][]string{c89Extra, gnuExtra}, true, ""},
+		"gnu99": {[][]string{gnuExtra}, true, "199901L"},
+		"gnu11": {[][]string{c11Extra, gnuExtra}, true, "201112L"},
+	}
+)
+
+func checkStd(std string) error {
+	if _, ok := stds[std]; !ok {
+		return fmt.Errorf("unknown -std argument: %s (supported: c89, c99, c11, gnu89, gnu99, gnu11)", std)
+	}
+
+	return nil
+}
+
+// stdExtra returns the cc extensions enabled by std.
+func stdExtra(std string) (r []string) {
+	for _, v := range stds[std].extra {
+		r = append(r, v...)
+	}
+	return clean(r)
+}
+
+// stdPredefined returns the preprocessor directives implied by std. Macros
+// already defined by D are left alone.
+func stdPredefined(std string, D []string) (r []string) {
+	if std == "" {
+		return nil
+	}
+
+	m := map[string]bool{}
+	for _, v := range D {
+		m[defineName(v)] = true
+	}
+	s := stds[std]
+	var a []string
+	switch {
+	case s.gnu:
+		a = []string{"__GNUC__=4", "__GNUC_MINOR__=2", "__GNUC_PATCHLEVEL__=1"}
+	default:
+		a = []string{"__STRICT_ANSI__"}
+	}
+	if !m["__STDC_VERSION__"] {
+		r = append(r, "#undef __STDC_VERSION__")
+		if s.version != "" {
+			a = append(a, "__STDC_VERSION__="+s.version)
+		}
+	}
+	for _, v := range a {
+		if d := define(v); !m[defineName(d)] {
+			r = append(r, d)
+		}
+	}
+	return r
+}
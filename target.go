@@ -0,0 +1,89 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/cznic/cc"
+	"github.com/cznic/ccir"
+)
+
+// targets lists the supported GOOS/GOARCH combinations.
+var targets = map[string]struct{}{
+	"linux/386":     {},
+	"linux/amd64":   {},
+	"windows/386":   {},
+	"windows/amd64": {},
+}
+
+type target struct {
+	os   string
+	arch string
+}
+
+func hostTarget() target { return target{runtime.GOOS, runtime.GOARCH} }
+
+func parseTarget(s string) (target, error) {
+	if _, ok := targets[s]; !ok {
+		var a []string
+		for k := range targets {
+			a = append(a, k)
+		}
+		sort.Strings(a)
+		return target{}, fmt.Errorf("unsupported target %q, supported targets: %s", s, strings.Join(a, ", "))
+	}
+
+	p := strings.SplitN(s, "/", 2)
+	t := target{p[0], p[1]}
+	if h := hostTarget(); t.layout() != h.layout() {
+		return target{}, fmt.Errorf("target %s: data layout differs from the host %s, cross compiling is not supported", t, h)
+	}
+
+	return t, nil
+}
+
+func (t target) String() string { return t.os + "/" + t.arch }
+
+// layout describes the sizes and alignments in which the supported targets
+// differ.
+type layout struct {
+	ptr     int // Size of pointers.
+	long    int // Size of long.
+	align64 int // Struct alignment of 64 bit types.
+}
+
+func (t target) layout() layout {
+	l := layout{8, 8, 8}
+	if t.arch == "386" {
+		l.ptr = 4
+	}
+	if t.arch == "386" || t.os == "windows" {
+		l.long = 4
+	}
+	if t.os == "linux" && t.arch == "386" {
+		// i386 System V ABI.
+		l.align64 = 4
+	}
+	return l
+}
+
+// model returns the C model of t. Both the IR and the virtual machine use the
+// host data layout, so t must have the layout of the host, see parseTarget.
+func (t target) model() (*cc.Model, error) { return ccir.NewModel() }
+
+// predefined returns the source text predefined in every translation unit
+// compiled for t.
+func (t target) predefined(D []string) string {
+	return fmt.Sprintf(`
+%s
+#define __arch__ %s
+#define __os__ %s
+#include <builtin.h>
+`, strings.Join(D, "\n"), t.arch, t.os)
+}
@@ -0,0 +1,7 @@
+#include <stdio.h>
+
+static char *file = __FILE__;
+
+int main() {
+	printf("%s %s:%d\n", file, __FILE__, __LINE__);
+}
@@ -0,0 +1,427 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vfs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func caller(s string, va ...interface{}) {
+	if s == "" {
+		s = strings.Repeat("%v ", len(va))
+	}
+	_, fn, fl, _ := runtime.Caller(2)
+	fmt.Fprintf(os.Stderr, "# caller: %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintf(os.Stderr, s, va...)
+	fmt.Fprintln(os.Stderr)
+	_, fn, fl, _ = runtime.Caller(1)
+	fmt.Fprintf(os.Stderr, "# \tcallee: %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintln(os.Stderr)
+	os.Stderr.Sync()
+}
+
+func dbg(s string, va ...interface{}) {
+	if s == "" {
+		s = strings.Repeat("%v ", len(va))
+	}
+	_, fn, fl, _ := runtime.Caller(1)
+	fmt.Fprintf(os.Stderr, "# dbg %s:%d: ", path.Base(fn), fl)
+	fmt.Fprintf(os.Stderr, s, va...)
+	fmt.Fprintln(os.Stderr)
+	os.Stderr.Sync()
+}
+
+func TODO(...interface{}) string { //TODOOK
+	_, fn, fl, _ := runtime.Caller(1)
+	return fmt.Sprintf("# TODO: %s:%d:\n", path.Base(fn), fl) //TODOOK
+}
+
+func use(...interface{}) {}
+
+func init() {
+	use(caller, dbg, TODO) //TODOOK
+}
+
+// ============================================================================
+
+func readFile(t *testing.T, fs FileSystem, name string) string {
+	f, err := fs.Open(name, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+func writeFile(fs FileSystem, name, s string) error {
+	f, err := fs.Open(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write([]byte(s)); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func readDir(t *testing.T, fs FileSystem, name string) string {
+	f, err := fs.Open(name, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	a, err := f.Readdir(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var r []string
+	for _, v := range a {
+		r = append(r, v.Name())
+	}
+	return strings.Join(r, " ")
+}
+
+func TestMem(t *testing.T) {
+	m := NewMem()
+	if err := m.MkdirAll("/a/b", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFile(m, "/a/b/c", "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := m.Open("a/b/c", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Write([]byte("bar"))
+	f.Close()
+	if g, e := readFile(t, m, "/a/b/c"), "foobar"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	if _, err := m.Open("/a/b/c", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644); !os.IsExist(err) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := m.Remove("/a"); err == nil {
+		t.Fatal("removed non empty directory")
+	}
+
+	if err := m.Rename("/a/b/c", "/a/d"); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := readDir(t, m, "/a"), "b d"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	if _, err := m.Stat("/a/b/c"); !os.IsNotExist(err) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if _, err := m.Stat("/../a/d"); err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Rename("/a", "/a/b/e")
+	if e, ok := err.(*os.PathError); !ok || e.Err != syscall.EINVAL {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if _, err := m.Stat("/a/b"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	m := NewMem()
+	if err := m.WriteFile("/f", []byte("foo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := ReadOnly(m)
+	if err := writeFile(fs, "/f", "bar"); !os.IsPermission(err) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := fs.Remove("/f"); !os.IsPermission(err) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if g, e := readFile(t, fs, "/f"), "foo"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	m := NewMem()
+	m.MkdirAll("/d", 0755)
+	m.WriteFile("/d/a", []byte("a"), 0644)
+	m.WriteFile("/d/b", []byte("b"), 0644)
+	fs := Overlay(ReadOnly(m))
+	if err := writeFile(fs, "/d/a", "A"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFile(fs, "/d/c", "C"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Remove("/d/b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := readFile(t, fs, "/d/a"), "A"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	if g, e := readDir(t, fs, "/d"), "a c"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	if _, err := fs.Stat("/d/b"); !os.IsNotExist(err) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := writeFile(fs, "/x/y", ""); !os.IsNotExist(err) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// The lower file system must be left untouched.
+	if g, e := readFile(t, m, "/d/a"), "a"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	if g, e := readDir(t, m, "/d"), "a b"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	root := NewMem()
+	root.WriteFile("/f", []byte("root"), 0644)
+	data := NewMem()
+	data.WriteFile("/f", []byte("data"), 0644)
+	var ns Namespace
+	ns.Mount("/", root)
+	ns.Mount("/mnt/data", ReadOnly(data))
+	if g, e := readFile(t, &ns, "/f"), "root"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	if g, e := readFile(t, &ns, "/mnt/data/f"), "data"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	if err := writeFile(&ns, "/mnt/data/f", ""); !os.IsPermission(err) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := ns.Rename("/f", "/mnt/data/g"); err == nil {
+		t.Fatal("rename across mounts succeeded")
+	}
+
+	// File systems of uncomparable types.
+	var ns3 Namespace
+	ns3.Mount("/", HTTP(httpDirs{{root}}))
+	if err := ns3.Rename("/f", "/g"); !os.IsPermission(err) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	var ns2 Namespace
+	ns2.Mount("/mnt/data", data)
+	if g, e := readDir(t, &ns2, "/"), "mnt"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	if _, err := ns2.Stat("/f"); !os.IsNotExist(err) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestDir(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "99c-vfs-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tmp)
+
+	root := filepath.Join(tmp, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(tmp, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := Dir(root)
+	if err := writeFile(fs, "/f", "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := readFile(t, fs, "/../../f"), "foo"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	if _, err := fs.Stat("../secret"); !os.IsNotExist(err) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	if err := os.Symlink(filepath.Join(tmp, "secret"), filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fs.Open("/link", os.O_RDONLY, 0); !os.IsPermission(err) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := fs.Remove("/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(tmp, "secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Mkdir("/d", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFile(fs, "/d/f", "bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Rename("/d/f", "/d/g"); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := readFile(t, fs, "/d/g"), "bar"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	if runtime.GOOS != "linux" {
+		return
+	}
+
+	// Replace the checked directory d by a symbolic link before using it.
+	if err := os.RemoveAll(filepath.Join(root, "d")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(tmp, filepath.Join(root, "d")); err != nil {
+		t.Fatal(err)
+	}
+
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := hostPath("open", "/d/secret", root, filepath.Join(root, "d", "secret")); !os.IsPermission(err) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestArchives(t *testing.T) {
+	var zbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	w, err := zw.Create("a/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.Write([]byte("zip"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(zbuf.Bytes()), int64(zbuf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zfs, err := Zip(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := readFile(t, zfs, "/a/b.txt"), "zip"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	var tbuf bytes.Buffer
+	tw := tar.NewWriter(&tbuf)
+	tw.WriteHeader(&tar.Header{Name: "a/", Typeflag: tar.TypeDir, Mode: 0755})
+	tw.WriteHeader(&tar.Header{Name: "a/b.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 3})
+	tw.Write([]byte("tar"))
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tfs, err := Tar(&tbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := readFile(t, tfs, "/a/b.txt"), "tar"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	if err := writeFile(tfs, "/a/b.txt", ""); !os.IsPermission(err) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	m := NewMem()
+	m.WriteFile("/index.html", []byte("http"), 0644)
+	hfs := HTTP(httpDir{m})
+	if g, e := readFile(t, hfs, "index.html"), "http"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+}
+
+// httpDir adapts a FileSystem to http.FileSystem.
+type httpDir struct {
+	fs FileSystem
+}
+
+func (h httpDir) Open(name string) (http.File, error) { return h.fs.Open(name, os.O_RDONLY, 0) }
+
+// httpDirs is an http.FileSystem of an uncomparable type.
+type httpDirs []httpDir
+
+func (h httpDirs) Open(name string) (http.File, error) { return h[0].Open(name) }
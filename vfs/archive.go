@@ -0,0 +1,167 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vfs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// HTTP returns a read only file system serving the content of fs.
+func HTTP(fs http.FileSystem) FileSystem { return ReadOnly(httpFS{fs}) }
+
+type httpFS struct {
+	fs http.FileSystem
+}
+
+func (h httpFS) Open(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := h.fs.Open(clean(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return httpFile{f}, nil
+}
+
+func (h httpFS) Mkdir(name string, perm os.FileMode) error { return os.ErrPermission }
+
+func (h httpFS) Remove(name string) error { return os.ErrPermission }
+
+func (h httpFS) Rename(oldname, newname string) error { return os.ErrPermission }
+
+func (h httpFS) Stat(name string) (os.FileInfo, error) {
+	f, err := h.fs.Open(clean(name))
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return f.Stat()
+}
+
+type httpFile struct {
+	http.File
+}
+
+func (httpFile) Write([]byte) (int, error) { return 0, os.ErrPermission }
+
+// Zip returns a read only file system holding the content of r.
+func Zip(r *zip.Reader) (FileSystem, error) {
+	m := NewMem()
+	for _, v := range r.File {
+		if err := m.add(v.Name, v.Mode(), v.ModTime(), v.Open); err != nil {
+			return nil, err
+		}
+	}
+	return ReadOnly(m), nil
+}
+
+// Tar returns a read only file system holding the content of the tar archive
+// read from r. Entries other than regular files and directories are ignored.
+func Tar(r io.Reader) (FileSystem, error) {
+	m := NewMem()
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				return ReadOnly(m), nil
+			}
+
+			return nil, err
+		}
+
+		switch h.Typeflag {
+		case tar.TypeDir, tar.TypeReg, tar.TypeRegA:
+			if err := m.add(h.Name, h.FileInfo().Mode(), h.ModTime, func() (io.ReadCloser, error) { return ioutil.NopCloser(tr), nil }); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// OpenArchive returns a read only file system holding the content of the
+// archive file name. Supported are .zip, .tar, .tar.gz and .tgz files.
+func OpenArchive(name string) (FileSystem, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		fi, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+
+		r, err := zip.NewReader(f, fi.Size())
+		if err != nil {
+			return nil, err
+		}
+
+		return Zip(r)
+	case strings.HasSuffix(name, ".tar"):
+		return Tar(f)
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		r, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+
+		return Tar(r)
+	}
+	return nil, fmt.Errorf("unsupported archive: %s", name)
+}
+
+// add adds an archive entry to m.
+func (m *Mem) add(name string, mode os.FileMode, t time.Time, open func() (io.ReadCloser, error)) error {
+	name = clean(name)
+	if mode.IsDir() {
+		if err := m.MkdirAll(name, mode&os.ModePerm); err != nil {
+			return err
+		}
+	} else {
+		if err := m.MkdirAll(path.Dir(name), 0755); err != nil {
+			return err
+		}
+
+		r, err := open()
+		if err != nil {
+			return err
+		}
+
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+
+		if err := m.WriteFile(name, data, mode&os.ModePerm); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, _, n, err := m.lookup("add", name)
+	if err == nil && n != nil {
+		n.modTime = t
+	}
+	return err
+}
@@ -0,0 +1,161 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vfs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Dir returns a file system confined to the host directory root. Symbolic
+// links resolving outside of root are refused.
+func Dir(root string) FileSystem { return dir(root) }
+
+type dir string
+
+// path returns the host path of name or an error if it would escape the root.
+// A symbolic link in the last component of name is followed only if follow is
+// true. The returned path is valid until the returned function is called. See
+// hostPath for how it is protected from concurrent replacements of its
+// directories by symbolic links.
+func (d dir) path(op, name string, follow bool) (string, func(), error) {
+	root, err := filepath.EvalSymlinks(string(d))
+	if err != nil {
+		return "", nil, err
+	}
+
+	p := filepath.Join(root, filepath.FromSlash(clean(name)))
+	var r string
+	if follow {
+		r, err = filepath.EvalSymlinks(p)
+	}
+	if !follow || err != nil {
+		if err != nil && !os.IsNotExist(err) {
+			return "", nil, err
+		}
+
+		// Check the parent, the last component may not exist yet.
+		if r, err = filepath.EvalSymlinks(filepath.Dir(p)); err != nil {
+			return "", nil, err
+		}
+
+		r = filepath.Join(r, filepath.Base(p))
+	}
+	if r != root && !strings.HasPrefix(r, root+string(filepath.Separator)) {
+		return "", nil, pathError(op, name, os.ErrPermission)
+	}
+
+	return hostPath(op, name, root, r)
+}
+
+// recheck returns an error if fi, the file reached by op, is no more the file
+// name resolves to, ie. if a directory of name was replaced by a symbolic link
+// while op was performed.
+func (d dir) recheck(op, name string, follow bool, fi os.FileInfo) error {
+	p, done, err := d.path(op, name, follow)
+	if err != nil {
+		return err
+	}
+
+	defer done()
+
+	fi2, err := os.Lstat(p)
+	if err != nil {
+		return err
+	}
+
+	if !os.SameFile(fi, fi2) {
+		return pathError(op, name, os.ErrPermission)
+	}
+
+	return nil
+}
+
+func (d dir) Open(name string, flag int, perm os.FileMode) (File, error) {
+	p, done, err := d.path("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	defer done()
+
+	// p has no symbolic links, do not follow one put in place meanwhile.
+	f, err := os.OpenFile(p, flag|oNoFollow, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err == nil {
+		err = d.recheck("open", name, true, fi)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (d dir) Mkdir(name string, perm os.FileMode) error {
+	p, done, err := d.path("mkdir", name, false)
+	if err != nil {
+		return err
+	}
+
+	defer done()
+
+	return os.Mkdir(p, perm)
+}
+
+func (d dir) Remove(name string) error {
+	p, done, err := d.path("remove", name, false)
+	if err != nil {
+		return err
+	}
+
+	defer done()
+
+	return os.Remove(p)
+}
+
+func (d dir) Rename(oldname, newname string) error {
+	o, done, err := d.path("rename", oldname, false)
+	if err != nil {
+		return err
+	}
+
+	defer done()
+
+	n, done2, err := d.path("rename", newname, false)
+	if err != nil {
+		return err
+	}
+
+	defer done2()
+
+	return os.Rename(o, n)
+}
+
+func (d dir) Stat(name string) (os.FileInfo, error) {
+	p, done, err := d.path("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	defer done()
+
+	fi, err := os.Lstat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.recheck("stat", name, true, fi); err != nil {
+		return nil, err
+	}
+
+	return fi, nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const oNoFollow = syscall.O_NOFOLLOW
+
+// hostPath returns a host path of r, a path below root having no symbolic
+// links, which cannot be redirected outside of root by replacing a directory
+// of r by a symbolic link after r was checked. The parent directory of r is
+// opened one component at a time without following symbolic links and r is
+// reached through its file descriptor. The returned function releases the
+// descriptor.
+func hostPath(op, name, root, r string) (string, func(), error) {
+	if r == root {
+		return r, func() {}, nil
+	}
+
+	rel, err := filepath.Rel(root, filepath.Dir(r))
+	if err != nil {
+		return "", nil, err
+	}
+
+	fd, err := syscall.Open(root, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return "", nil, pathError(op, name, err)
+	}
+
+	if rel != "." {
+		for _, v := range strings.Split(rel, string(filepath.Separator)) {
+			fd2, err := syscall.Openat(fd, v, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+			syscall.Close(fd)
+			if err != nil {
+				if err == syscall.ELOOP || err == syscall.ENOTDIR {
+					// A component was replaced.
+					err = os.ErrPermission
+				}
+				return "", nil, pathError(op, name, err)
+			}
+
+			fd = fd2
+		}
+	}
+	return fmt.Sprintf("/proc/self/fd/%d/%s", fd, filepath.Base(r)), func() { syscall.Close(fd) }, nil
+}
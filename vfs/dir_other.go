@@ -0,0 +1,16 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package vfs
+
+const oNoFollow = 0
+
+// hostPath returns r. Without a way to open files relative to a directory
+// the check of r can be raced by replacing a directory of r by a symbolic
+// link. Open and Stat re-check the file they reach, see dir.recheck.
+func hostPath(op, name, root, r string) (string, func(), error) {
+	return r, func() {}, nil
+}
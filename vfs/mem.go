@@ -0,0 +1,309 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vfs
+
+import (
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Mem is a file system kept in memory. The zero value is not ready to use,
+// call NewMem instead.
+type Mem struct {
+	mu   sync.Mutex
+	root *memNode
+}
+
+type memNode struct {
+	children map[string]*memNode // Directories only.
+	data     []byte
+	mode     os.FileMode
+	modTime  time.Time
+}
+
+// NewMem returns a new, empty Mem.
+func NewMem() *Mem {
+	return &Mem{root: &memNode{children: map[string]*memNode{}, mode: os.ModeDir | 0755, modTime: time.Now()}}
+}
+
+func (n *memNode) info(name string) os.FileInfo {
+	return &fileInfo{name: name, size: int64(len(n.data)), mode: n.mode, modTime: n.modTime}
+}
+
+// lookup returns the parent directory of name, the base name and the node of
+// name, if it exists.
+func (m *Mem) lookup(op, name string) (parent *memNode, base string, n *memNode, err error) {
+	name = clean(name)
+	if name == "/" {
+		return nil, "/", m.root, nil
+	}
+
+	parent = m.root
+	a := strings.Split(name[1:], "/")
+	for _, v := range a[:len(a)-1] {
+		if parent = parent.children[v]; parent == nil {
+			return nil, "", nil, pathError(op, name, os.ErrNotExist)
+		}
+
+		if !parent.mode.IsDir() {
+			return nil, "", nil, pathError(op, name, syscall.ENOTDIR)
+		}
+	}
+	base = a[len(a)-1]
+	return parent, base, parent.children[base], nil
+}
+
+// Open implements FileSystem.
+func (m *Mem) Open(name string, flag int, perm os.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	parent, base, n, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case n == nil:
+		if flag&os.O_CREATE == 0 {
+			return nil, pathError("open", name, os.ErrNotExist)
+		}
+
+		n = &memNode{mode: perm & os.ModePerm, modTime: time.Now()}
+		parent.children[base] = n
+	case flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, pathError("open", name, os.ErrExist)
+	case n.mode.IsDir():
+		if flag&writeFlags != 0 {
+			return nil, pathError("open", name, syscall.EISDIR)
+		}
+
+		return m.dir(base, n), nil
+	}
+
+	if flag&os.O_TRUNC != 0 && flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		n.data = nil
+		n.modTime = time.Now()
+	}
+	return &memFile{m: m, n: n, name: base, flag: flag}, nil
+}
+
+func (m *Mem) dir(name string, n *memNode) *dirFile {
+	d := &dirFile{fi: n.info(name)}
+	for k, v := range n.children {
+		d.entries = append(d.entries, v.info(k))
+	}
+	sort.Slice(d.entries, func(i, j int) bool { return d.entries[i].Name() < d.entries[j].Name() })
+	return d
+}
+
+// Mkdir implements FileSystem.
+func (m *Mem) Mkdir(name string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	parent, base, n, err := m.lookup("mkdir", name)
+	if err != nil {
+		return err
+	}
+
+	if n != nil {
+		return pathError("mkdir", name, os.ErrExist)
+	}
+
+	parent.children[base] = &memNode{children: map[string]*memNode{}, mode: os.ModeDir | perm&os.ModePerm, modTime: time.Now()}
+	return nil
+}
+
+// MkdirAll creates the directory name and all its missing parents.
+func (m *Mem) MkdirAll(name string, perm os.FileMode) error {
+	name = clean(name)
+	if fi, err := m.Stat(name); err == nil {
+		if !fi.IsDir() {
+			return pathError("mkdir", name, syscall.ENOTDIR)
+		}
+
+		return nil
+	}
+
+	if err := m.MkdirAll(path.Dir(name), perm); err != nil {
+		return err
+	}
+
+	if err := m.Mkdir(name, perm); err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Remove implements FileSystem.
+func (m *Mem) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	parent, base, n, err := m.lookup("remove", name)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case n == nil:
+		return pathError("remove", name, os.ErrNotExist)
+	case parent == nil:
+		return pathError("remove", name, os.ErrPermission)
+	case len(n.children) != 0:
+		return pathError("remove", name, syscall.ENOTEMPTY)
+	}
+
+	delete(parent.children, base)
+	return nil
+}
+
+// Rename implements FileSystem.
+func (m *Mem) Rename(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	op, ob, on, err := m.lookup("rename", oldname)
+	if err != nil {
+		return err
+	}
+
+	np, nb, nn, err := m.lookup("rename", newname)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case on == nil:
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrNotExist}
+	case on.mode.IsDir() && strings.HasPrefix(clean(newname), clean(oldname)+"/"):
+		// Moving a directory below itself.
+		return pathError("rename", newname, syscall.EINVAL)
+	case op == nil || np == nil:
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrPermission}
+	case nn != nil && nn.mode.IsDir():
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrExist}
+	}
+
+	delete(op.children, ob)
+	np.children[nb] = on
+	return nil
+}
+
+// Stat implements FileSystem.
+func (m *Mem) Stat(name string) (os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, base, n, err := m.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if n == nil {
+		return nil, pathError("stat", name, os.ErrNotExist)
+	}
+
+	return n.info(base), nil
+}
+
+// WriteFile creates or truncates the file name and writes data to it.
+func (m *Mem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	f, err := m.Open(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+type memFile struct {
+	flag int
+	m    *Mem
+	n    *memNode
+	name string
+	off  int64
+}
+
+func (f *memFile) Close() error { return nil }
+
+func (f *memFile) Read(b []byte) (int, error) {
+	if f.flag&os.O_WRONLY != 0 {
+		return 0, pathError("read", f.name, syscall.EBADF)
+	}
+
+	f.m.mu.Lock()
+	defer f.m.mu.Unlock()
+
+	if f.off >= int64(len(f.n.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(b, f.n.data[f.off:])
+	f.off += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Readdir(int) ([]os.FileInfo, error) {
+	return nil, pathError("readdir", f.name, syscall.ENOTDIR)
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.m.mu.Lock()
+	defer f.m.mu.Unlock()
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += int64(len(f.n.data))
+	}
+	if offset < 0 {
+		return 0, pathError("seek", f.name, syscall.EINVAL)
+	}
+
+	f.off = offset
+	return offset, nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	f.m.mu.Lock()
+	defer f.m.mu.Unlock()
+
+	return f.n.info(f.name), nil
+}
+
+func (f *memFile) Write(b []byte) (int, error) {
+	if f.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return 0, pathError("write", f.name, syscall.EBADF)
+	}
+
+	f.m.mu.Lock()
+	defer f.m.mu.Unlock()
+
+	if f.flag&os.O_APPEND != 0 {
+		f.off = int64(len(f.n.data))
+	}
+	if n := f.off + int64(len(b)); n > int64(len(f.n.data)) {
+		f.n.data = append(f.n.data, make([]byte, n-int64(len(f.n.data)))...)
+	}
+	copy(f.n.data[f.off:], b)
+	f.off += int64(len(b))
+	f.n.modTime = time.Now()
+	return len(b), nil
+}
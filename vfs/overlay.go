@@ -0,0 +1,252 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vfs
+
+import (
+	"io"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+// Overlay returns a file system presenting the content of lower while keeping
+// all modifications in memory. The lower file system is never written to.
+func Overlay(lower FileSystem) FileSystem {
+	return &overlay{lower: lower, upper: NewMem(), deleted: map[string]bool{}}
+}
+
+type overlay struct {
+	deleted map[string]bool // Names removed from lower.
+	lower   FileSystem
+	mu      sync.Mutex
+	upper   *Mem
+}
+
+// stat returns the file info of name and whether it is present in upper.
+func (o *overlay) stat(name string) (os.FileInfo, bool, error) {
+	if fi, err := o.upper.Stat(name); err == nil {
+		return fi, true, nil
+	}
+
+	for p := name; ; p = path.Dir(p) {
+		if o.deleted[p] {
+			return nil, false, pathError("stat", name, os.ErrNotExist)
+		}
+
+		if p == "/" {
+			break
+		}
+	}
+	fi, err := o.lower.Stat(name)
+	return fi, false, err
+}
+
+// copyUp makes sure name, if it exists, and its parent directories are present
+// in upper.
+func (o *overlay) copyUp(name string) error {
+	fi, inUpper, err := o.stat(name)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+
+		if dir := path.Dir(name); dir != name {
+			if fi, _, err := o.stat(dir); err != nil || !fi.IsDir() {
+				return pathError("open", name, os.ErrNotExist)
+			}
+
+			return o.copyUp(dir)
+		}
+
+		return nil
+	}
+
+	if inUpper {
+		return nil
+	}
+
+	if err := o.copyUp(path.Dir(name)); err != nil {
+		return err
+	}
+
+	if fi.IsDir() {
+		return o.upper.Mkdir(name, fi.Mode()&os.ModePerm)
+	}
+
+	f, err := o.lower.Open(name, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	g, err := o.upper.Open(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode()&os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(g, f)
+	return err
+}
+
+func (o *overlay) Open(name string, flag int, perm os.FileMode) (File, error) {
+	name = clean(name)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if flag&writeFlags != 0 {
+		if err := o.copyUp(name); err != nil {
+			return nil, err
+		}
+
+		f, err := o.upper.Open(name, flag, perm)
+		if err == nil {
+			delete(o.deleted, name)
+		}
+		return f, err
+	}
+
+	fi, inUpper, err := o.stat(name)
+	switch {
+	case err != nil:
+		return nil, err
+	case fi.IsDir():
+		return o.dir(name, fi)
+	case inUpper:
+		return o.upper.Open(name, flag, perm)
+	}
+
+	f, err := o.lower.Open(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return readOnlyFile{f}, nil
+}
+
+// dir returns a directory listing merging upper and lower.
+func (o *overlay) dir(name string, fi os.FileInfo) (File, error) {
+	m := map[string]os.FileInfo{}
+	for _, fs := range []FileSystem{o.lower, o.upper} {
+		f, err := fs.Open(name, os.O_RDONLY, 0)
+		if err != nil {
+			continue
+		}
+
+		a, err := f.Readdir(-1)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range a {
+			m[v.Name()] = v
+		}
+	}
+	d := &dirFile{fi: fi}
+	for k, v := range m {
+		if _, _, err := o.stat(path.Join(name, k)); err == nil {
+			d.entries = append(d.entries, v)
+		}
+	}
+	sort.Slice(d.entries, func(i, j int) bool { return d.entries[i].Name() < d.entries[j].Name() })
+	return d, nil
+}
+
+func (o *overlay) Mkdir(name string, perm os.FileMode) error {
+	name = clean(name)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if _, _, err := o.stat(name); err == nil {
+		return pathError("mkdir", name, os.ErrExist)
+	}
+
+	if err := o.copyUp(path.Dir(name)); err != nil {
+		return err
+	}
+
+	if err := o.upper.Mkdir(name, perm); err != nil {
+		return err
+	}
+
+	delete(o.deleted, name)
+	return nil
+}
+
+func (o *overlay) Remove(name string) error {
+	name = clean(name)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	fi, inUpper, err := o.stat(name)
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() {
+		f, err := o.dir(name, fi)
+		if err != nil {
+			return err
+		}
+
+		if a, _ := f.Readdir(1); len(a) != 0 {
+			return pathError("remove", name, syscall.ENOTEMPTY)
+		}
+	}
+
+	if inUpper {
+		if err := o.upper.Remove(name); err != nil {
+			return err
+		}
+	}
+
+	o.deleted[name] = true
+	return nil
+}
+
+func (o *overlay) Rename(oldname, newname string) error {
+	oldname = clean(oldname)
+	newname = clean(newname)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	fi, _, err := o.stat(oldname)
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() {
+		// Renaming a directory would require copying up its whole
+		// subtree.
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrPermission}
+	}
+
+	if err := o.copyUp(oldname); err != nil {
+		return err
+	}
+
+	if err := o.copyUp(path.Dir(newname)); err != nil {
+		return err
+	}
+
+	if err := o.upper.Rename(oldname, newname); err != nil {
+		return err
+	}
+
+	o.deleted[oldname] = true
+	delete(o.deleted, newname)
+	return nil
+}
+
+func (o *overlay) Stat(name string) (os.FileInfo, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	fi, _, err := o.stat(clean(name))
+	return fi, err
+}
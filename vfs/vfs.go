@@ -0,0 +1,299 @@
+// Copyright 2017 The 99c Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package vfs provides file systems confining the file access of programs.
+//
+// All names passed to a FileSystem are slash separated. Relative names are
+// interpreted relative to the root of the file system and names cannot escape
+// the root using "..".
+//
+// The virtual machine does not route the file access of the programs it
+// executes through a FileSystem, so the package is useful to Go programs
+// serving files to programs themselves, eg. in their FFI functions. For
+// example, a file system seeing only the content of an http.FileSystem, but
+// able to create temporary files
+//
+//	var ns vfs.Namespace
+//	ns.Mount("/", vfs.HTTP(fs))
+//	ns.Mount("/tmp", vfs.NewMem())
+//	f, err := ns.Open("/tmp/out", os.O_RDWR|os.O_CREATE, 0600)
+package vfs
+
+import (
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// File is an open file of a FileSystem.
+type File interface {
+	io.Closer
+	io.Reader
+	io.Seeker
+	io.Writer
+	Readdir(count int) ([]os.FileInfo, error)
+	Stat() (os.FileInfo, error)
+}
+
+// FileSystem is the file system seen by a program.
+type FileSystem interface {
+	// Open opens the named file using the os.O_* flag and perm as
+	// os.OpenFile does.
+	Open(name string, flag int, perm os.FileMode) (File, error)
+	Mkdir(name string, perm os.FileMode) error
+	Remove(name string) error
+	Rename(oldname, newname string) error
+	Stat(name string) (os.FileInfo, error)
+}
+
+const writeFlags = os.O_WRONLY | os.O_RDWR | os.O_CREATE | os.O_TRUNC | os.O_APPEND
+
+// clean returns the canonical absolute form of name.
+func clean(name string) string { return path.Clean("/" + name) }
+
+func pathError(op, name string, err error) error {
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// ReadOnly returns a file system rejecting any modification of fs.
+func ReadOnly(fs FileSystem) FileSystem { return readOnly{fs} }
+
+type readOnly struct {
+	FileSystem
+}
+
+func (r readOnly) Open(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&writeFlags != 0 {
+		return nil, pathError("open", name, os.ErrPermission)
+	}
+
+	f, err := r.FileSystem.Open(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return readOnlyFile{f}, nil
+}
+
+func (readOnly) Mkdir(name string, perm os.FileMode) error {
+	return pathError("mkdir", name, os.ErrPermission)
+}
+
+func (readOnly) Remove(name string) error { return pathError("remove", name, os.ErrPermission) }
+
+func (readOnly) Rename(oldname, newname string) error {
+	return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrPermission}
+}
+
+type readOnlyFile struct {
+	File
+}
+
+func (f readOnlyFile) Write([]byte) (int, error) { return 0, os.ErrPermission }
+
+type fileInfo struct {
+	mode    os.FileMode
+	modTime time.Time
+	name    string
+	size    int64
+}
+
+func (fi *fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+// dirFile is a read only directory having a fixed list of entries.
+type dirFile struct {
+	fi      os.FileInfo
+	entries []os.FileInfo
+}
+
+func (d *dirFile) Close() error                   { return nil }
+func (d *dirFile) Read([]byte) (int, error)       { return 0, pathError("read", d.fi.Name(), syscall.EISDIR) }
+func (d *dirFile) Seek(int64, int) (int64, error) { return 0, nil }
+func (d *dirFile) Stat() (os.FileInfo, error)     { return d.fi, nil }
+func (d *dirFile) Write([]byte) (int, error) {
+	return 0, pathError("write", d.fi.Name(), syscall.EISDIR)
+}
+
+func (d *dirFile) Readdir(count int) ([]os.FileInfo, error) {
+	if count <= 0 {
+		r := d.entries
+		d.entries = nil
+		return r, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	if count > len(d.entries) {
+		count = len(d.entries)
+	}
+	r := d.entries[:count]
+	d.entries = d.entries[count:]
+	return r, nil
+}
+
+// Namespace is a FileSystem composed of file systems mounted at directories.
+// Names not covered by any mount do not exist, except for the parent
+// directories of the mount points. The zero value is an empty namespace ready
+// to use.
+type Namespace struct {
+	mounts []mount
+	mu     sync.RWMutex
+}
+
+type mount struct {
+	dir string
+	fs  FileSystem
+}
+
+// Mount mounts fs at dir. Mounting at "/" makes fs the root file system. A
+// later mount at the same directory replaces the earlier one.
+func (ns *Namespace) Mount(dir string, fs FileSystem) {
+	dir = clean(dir)
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	for i, v := range ns.mounts {
+		if v.dir == dir {
+			ns.mounts[i].fs = fs
+			return
+		}
+	}
+
+	ns.mounts = append(ns.mounts, mount{dir, fs})
+	sort.Slice(ns.mounts, func(i, j int) bool { return len(ns.mounts[i].dir) > len(ns.mounts[j].dir) })
+}
+
+// resolve returns the mount name belongs to and the name within its file
+// system.
+func (ns *Namespace) resolve(name string) (mount, string, bool) {
+	name = clean(name)
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+
+	for _, v := range ns.mounts {
+		switch {
+		case v.dir == "/":
+			return v, name, true
+		case name == v.dir:
+			return v, "/", true
+		case strings.HasPrefix(name, v.dir+"/"):
+			return v, name[len(v.dir):], true
+		}
+	}
+	return mount{}, "", false
+}
+
+// children returns the names of the mount point components directly below
+// dir, if any.
+func (ns *Namespace) children(dir string) []string {
+	dir = clean(dir)
+	prefix := dir + "/"
+	if dir == "/" {
+		prefix = dir
+	}
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+
+	m := map[string]struct{}{}
+	for _, v := range ns.mounts {
+		if v.dir != dir && strings.HasPrefix(v.dir, prefix) {
+			m[strings.SplitN(v.dir[len(prefix):], "/", 2)[0]] = struct{}{}
+		}
+	}
+	var r []string
+	for k := range m {
+		r = append(r, k)
+	}
+	sort.Strings(r)
+	return r
+}
+
+func (ns *Namespace) syntheticDir(name string) (*dirFile, bool) {
+	a := ns.children(name)
+	if len(a) == 0 {
+		return nil, false
+	}
+
+	d := &dirFile{fi: &fileInfo{name: path.Base(clean(name)), mode: os.ModeDir | 0555}}
+	for _, v := range a {
+		d.entries = append(d.entries, &fileInfo{name: v, mode: os.ModeDir | 0555})
+	}
+	return d, true
+}
+
+// Open implements FileSystem.
+func (ns *Namespace) Open(name string, flag int, perm os.FileMode) (File, error) {
+	m, nm, ok := ns.resolve(name)
+	if !ok {
+		if d, ok := ns.syntheticDir(name); ok && flag&writeFlags == 0 {
+			return d, nil
+		}
+
+		return nil, pathError("open", name, os.ErrNotExist)
+	}
+
+	return m.fs.Open(nm, flag, perm)
+}
+
+// Mkdir implements FileSystem.
+func (ns *Namespace) Mkdir(name string, perm os.FileMode) error {
+	m, nm, ok := ns.resolve(name)
+	if !ok {
+		return pathError("mkdir", name, os.ErrPermission)
+	}
+
+	return m.fs.Mkdir(nm, perm)
+}
+
+// Remove implements FileSystem.
+func (ns *Namespace) Remove(name string) error {
+	m, nm, ok := ns.resolve(name)
+	if !ok {
+		return pathError("remove", name, os.ErrNotExist)
+	}
+
+	return m.fs.Remove(nm)
+}
+
+// Rename implements FileSystem.
+func (ns *Namespace) Rename(oldname, newname string) error {
+	m, o, ok := ns.resolve(oldname)
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrNotExist}
+	}
+
+	// The file systems need not be comparable, compare the mount points.
+	m2, n, ok := ns.resolve(newname)
+	if !ok || m2.dir != m.dir {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EXDEV}
+	}
+
+	return m.fs.Rename(o, n)
+}
+
+// Stat implements FileSystem.
+func (ns *Namespace) Stat(name string) (os.FileInfo, error) {
+	m, nm, ok := ns.resolve(name)
+	if !ok {
+		if d, ok := ns.syntheticDir(name); ok {
+			return d.fi, nil
+		}
+
+		return nil, pathError("stat", name, os.ErrNotExist)
+	}
+
+	return m.fs.Stat(nm)
+}